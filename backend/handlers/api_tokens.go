@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+const apiTokenPrefix = "scyd_"
+
+// the last use of a token is saved at most once per interval
+const apiTokenTouchInterval = time.Minute
+
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func generateAPIToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// returns the token of an "Authorization: Bearer <token>" header
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	scheme, token, found := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// looks up the token of the request, expired tokens and tokens of removed users are refused
+func authenticateAPIToken(c *fiber.Ctx) (*models.APIToken, bool) {
+	value, ok := bearerToken(c)
+	if !ok {
+		return nil, false
+	}
+
+	apiTokenService := services.NewAPITokenService()
+
+	token, err := apiTokenService.GetTokenByHash(hashAPIToken(value))
+	if err != nil {
+		return nil, false
+	}
+
+	now := time.Now()
+
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, false
+	}
+
+	if _, exists := utils.UserConfig.Users[token.Username]; !exists {
+		return nil, false
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval {
+		apiTokenService.TouchToken(token.ID, now)
+	}
+
+	return token, true
+}
+
+// reports whether the token can call an operation requiring the given scope
+// operations without a scope can only be called by unrestricted tokens
+func tokenAllows(token *models.APIToken, scope string) bool {
+	if len(token.Scopes) == 0 {
+		return true
+	}
+	return scope != "" && slices.Contains(token.Scopes, scope)
+}
+
+type CreateAPITokenRequest struct {
+	Body struct {
+		Name          string   `json:"name" required:"true" minLength:"1" doc:"Name of the client using the token"`
+		Scopes        []string `json:"scopes,omitempty" required:"false" enum:"download:create,download:read,download:manage,sort,library:read,library:write,maintenance" doc:"Operations the token is allowed to call, all of them when empty"`
+		ExpiresInDays int      `json:"expires_in_days,omitempty" required:"false" minimum:"0" doc:"The token expires after this many days, never when 0"`
+	}
+}
+
+type CreateAPITokenResponse struct {
+	Body struct {
+		models.APIToken
+		// only returned once, it can't be read again
+		Token string `json:"token"`
+	}
+}
+
+type GetAPITokensResponse struct {
+	Body []models.APIToken
+}
+
+// CreateAPITokenHandler creates a personal access token for the current user
+func CreateAPITokenHandler(ctx context.Context, input *CreateAPITokenRequest) (*CreateAPITokenResponse, error) {
+	value, err := generateAPIToken()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to generate token: " + err.Error())
+	}
+
+	scopes := []string{}
+	for _, scope := range input.Body.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	token := models.APIToken{
+		Username:  requestUsername(ctx),
+		Name:      input.Body.Name,
+		TokenHash: hashAPIToken(value),
+		Prefix:    value[:len(apiTokenPrefix)+6],
+		Scopes:    scopes,
+	}
+
+	if input.Body.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, input.Body.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := services.NewAPITokenService().CreateToken(&token); err != nil {
+		return nil, huma.Error500InternalServerError("Failed to create token: " + err.Error())
+	}
+
+	res := &CreateAPITokenResponse{}
+	res.Body.APIToken = token
+	res.Body.Token = value
+
+	return res, nil
+}
+
+// GetAPITokensHandler lists the tokens of the current user
+func GetAPITokensHandler(ctx context.Context, input *struct{}) (*GetAPITokensResponse, error) {
+	tokens, err := services.NewAPITokenService().GetUserTokens(requestUsername(ctx))
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get tokens: " + err.Error())
+	}
+
+	return &GetAPITokensResponse{Body: tokens}, nil
+}
+
+// RevokeAPITokenHandler deletes a token of the current user, it stops working immediately
+func RevokeAPITokenHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*struct{}, error) {
+	deleted, err := services.NewAPITokenService().DeleteUserToken(input.ID, requestUsername(ctx))
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to revoke token: " + err.Error())
+	}
+	if !deleted {
+		return nil, huma.Error404NotFound("Token not found")
+	}
+
+	return nil, nil
+}
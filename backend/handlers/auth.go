@@ -30,6 +30,8 @@ type AuthSuccessBody struct {
 type AuthStatusBody struct {
 	Authenticated bool   `json:"authenticated"`
 	Username      string `json:"username,omitempty"`
+	// admin, user or viewer
+	Role utils.Role `json:"role,omitempty" enum:"admin,user,viewer"`
 }
 
 func setupSessionStore() *session.Store {
@@ -151,25 +153,46 @@ func isAuthenticated(c *fiber.Ctx) (*session.Session, bool) {
 // AuthStatusHandler returns the current authentication status (Huma handler)
 func AuthStatusHandler(ctx context.Context, input *AuthStatusRequest) (*AuthStatusResponse, error) {
 	c := utils.GetFiberCtx(ctx)
-	session, authenticated := isAuthenticated(c)
 
 	username := ""
+	authenticated := false
 
-	if authenticated {
-		username = session.Get("username").(string)
+	if _, hasToken := bearerToken(c); hasToken {
+		if token, ok := authenticateAPIToken(c); ok {
+			username, authenticated = token.Username, true
+		}
+	} else if session, ok := isAuthenticated(c); ok {
+		username, authenticated = session.Get("username").(string), true
 	}
 
+	role, _ := utils.GetUserRole(username)
+
 	return &AuthStatusResponse{
 		Body: AuthStatusBody{
 			Authenticated: authenticated,
 			Username:      username,
+			Role:          role,
 		},
 	}, nil
 }
 
 func AuthMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		_, authenticated := isAuthenticated(c)
+		// automation clients send a personal API token instead of the session cookie
+		if _, hasToken := bearerToken(c); hasToken {
+			token, ok := authenticateAPIToken(c)
+			if !ok {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid, expired or revoked API token",
+				})
+			}
+
+			c.Locals(localsUsername, token.Username)
+			c.Locals(localsAPIToken, token)
+			return c.Next()
+		}
+
+		session, authenticated := isAuthenticated(c)
 
 		if !authenticated {
 			return c.Status(401).JSON(fiber.Map{
@@ -177,6 +200,7 @@ func AuthMiddleware() fiber.Handler {
 			})
 		}
 
+		c.Locals(localsUsername, session.Get("username"))
 		return c.Next()
 	}
 }
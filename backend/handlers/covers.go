@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/nicolassutter/scyd/utils"
+)
+
+// returns the image data of the cover planned for an entry, or nil
+func readEntryCover(entry *SortPlanEntry) []byte {
+	switch entry.Cover {
+	case "":
+		return nil
+	case "embedded":
+		if picture := entry.group.Metadata.Picture(); picture != nil {
+			return picture.Data
+		}
+		return nil
+	default:
+		data, err := os.ReadFile(entry.Cover)
+		if err != nil {
+			log.Printf("Failed to read cover %s: %v", entry.Cover, err)
+			return nil
+		}
+		return data
+	}
+}
+
+// writes the planned cover into the album folder of a sorted entry, unless one appeared meanwhile
+func applyEntryCover(entry *SortPlanEntry, journal *sortJournal) {
+	dir := filepath.Dir(entry.Destination)
+
+	if entry.Cover == "" || utils.FindCoverFile(dir) != "" {
+		return
+	}
+
+	data := readEntryCover(entry)
+	if data == nil {
+		return
+	}
+
+	coverPath, err := utils.WriteCoverFile(dir, data)
+	if err != nil {
+		log.Printf("Failed to write cover into %s: %v", dir, err)
+		return
+	}
+
+	journal.recordCreated(coverPath)
+}
+
+// embeds the album cover into an audio file without artwork, before it is moved
+// the cover comes from the download directory or from the album folder it is sorted into
+func embedMissingCover(entry *SortPlanEntry) {
+	if !utils.UserConfig.Covers.EmbedMissing || entry.group.Metadata.Picture() != nil || !utils.IsTagWritable(entry.Source) {
+		return
+	}
+
+	coverPath := entry.group.DirCover
+	if coverPath == "" {
+		coverPath = utils.FindCoverFile(filepath.Dir(entry.Destination))
+	}
+
+	if coverPath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(coverPath)
+	if err != nil {
+		log.Printf("Failed to read cover %s: %v", coverPath, err)
+		return
+	}
+
+	if err := utils.WriteTags(entry.Source, utils.TagUpdate{Cover: data}); err != nil {
+		log.Printf("Failed to embed cover into %s: %v", entry.Source, err)
+	}
+}
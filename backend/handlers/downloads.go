@@ -4,10 +4,15 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 
@@ -20,6 +25,7 @@ import (
 	"github.com/nicolassutter/scyd/models"
 	"github.com/nicolassutter/scyd/services"
 	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
 )
 
 type DownloadResponse struct {
@@ -31,7 +37,8 @@ type DownloadResponseBody struct {
 	DownloadID uint   `json:"download_id"`
 }
 
-func broadcastDownloadMessage(msg DownloadMessage) {
+// sends the message to the clients of the download owner and to the admins
+func broadcastDownloadMessage(owner string, msg DownloadMessage) {
 	msgBytes, err := json.Marshal(msg)
 
 	if err != nil {
@@ -43,12 +50,20 @@ func broadcastDownloadMessage(msg DownloadMessage) {
 	defer clientsMutex.RUnlock()
 
 	for _, client := range clients {
-		if client != nil {
-			client.Emit(msgBytes)
+		if client.socket != nil && canAccessDownload(client.username, owner) {
+			client.socket.Emit(msgBytes)
 		}
 	}
 }
 
+// reports whether a user can see and manage a download, admins can access every download
+func canAccessDownload(username string, owner string) bool {
+	if role, _ := utils.GetUserRole(username); role == utils.RoleAdmin {
+		return true
+	}
+	return username != "" && username == owner
+}
+
 type DownloadEvent string
 
 const (
@@ -64,17 +79,26 @@ type DownloadMessage struct {
 	Data       string        `json:"data"`
 }
 
+type downloadClient struct {
+	socket *socketio.Websocket
+	// user authenticated when the connection was opened
+	username string
+}
+
 // key: connection uuid
-var clients = make(map[string]*socketio.Websocket)
+var clients = make(map[string]downloadClient)
 var clientsMutex sync.RWMutex
 
 type DownloadManager struct {
 	downloads map[uint]context.CancelFunc
-	mu        sync.RWMutex
+	// files each running download is writing, as output stems or as paths when the name has no stem
+	outputs map[uint][]string
+	mu      sync.RWMutex
 }
 
 var downloadManager = &DownloadManager{
 	downloads: make(map[uint]context.CancelFunc),
+	outputs:   make(map[uint][]string),
 }
 
 // stores a cancel function for a download
@@ -93,16 +117,70 @@ func (dm *DownloadManager) CancelDownload(downloadID uint) bool {
 	if cancel, exists := dm.downloads[downloadID]; exists {
 		cancel()
 		delete(dm.downloads, downloadID)
+		delete(dm.outputs, downloadID)
 		return true
 	}
 	return false
 }
 
+// reports whether a download command is currently running
+func (dm *DownloadManager) HasActiveDownloads() bool {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return len(dm.downloads) > 0
+}
+
 // removes a download from the map (for cleanup after completion)
 func (dm *DownloadManager) RemoveDownload(downloadID uint) {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 	delete(dm.downloads, downloadID)
+	delete(dm.outputs, downloadID)
+}
+
+// remembers the file named in a line of yt-dlp output, every file sharing its output name belongs to the download
+// "[download] Destination: /dl/x.webm", "[Merger] Merging formats into "/dl/x.mkv"", "[ExtractAudio] Destination: /dl/x.mp3"...
+func (dm *DownloadManager) RecordDownloadOutput(downloadID uint, line string) {
+	start := strings.Index(line, utils.UserConfig.DownloadDir+string(filepath.Separator))
+	if start < 0 {
+		return
+	}
+
+	path := strings.TrimSuffix(line[start:], " has already been downloaded")
+	path = strings.TrimRight(path, `"'`)
+
+	if stem, ok := downloadOutputStem(path); ok {
+		path = stem
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if _, running := dm.downloads[downloadID]; running && !slices.Contains(dm.outputs[downloadID], path) {
+		dm.outputs[downloadID] = append(dm.outputs[downloadID], path)
+	}
+}
+
+// forgets the files of a download, once it completed they can be sorted like any other file
+func (dm *DownloadManager) ReleaseDownloadOutputs(downloadID uint) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	delete(dm.outputs, downloadID)
+}
+
+// reports whether a running download is writing the file, intermediate files included
+func (dm *DownloadManager) IsDownloadOutput(path string) bool {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	for _, outputs := range dm.outputs {
+		for _, output := range outputs {
+			if path == output || strings.HasPrefix(path, output+".") {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // WebSocket handler for download connections
@@ -114,7 +192,7 @@ func SetupDownloadWebSocket(router *fiber.Router) {
 	})
 
 	// require websocket upgrade to access this route
-	(*router).Use("/ws/download", func(c *fiber.Ctx) error {
+	(*router).Use("/ws/download", RequireScopeHandler(ScopeDownloadRead), func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
 			c.Locals("allowed", true)
 			return c.Next()
@@ -124,12 +202,13 @@ func SetupDownloadWebSocket(router *fiber.Router) {
 
 	(*router).Get("/ws/download", socketio.New(func(kws *socketio.Websocket) {
 		clientsMutex.Lock()
-		clients[kws.UUID] = kws
+		username, _ := kws.Locals(localsUsername).(string)
+		clients[kws.UUID] = downloadClient{socket: kws, username: username}
 		clientsMutex.Unlock()
 	}))
 }
 
-func startDownloadTaskWS(downloadID uint, commandArgs []string) {
+func startDownloadTaskWS(downloadID uint, owner string, commandArgs []string) {
 	downloadService := services.NewDownloadService()
 	var errorMessage string
 
@@ -148,7 +227,7 @@ func startDownloadTaskWS(downloadID uint, commandArgs []string) {
 		// the context was cancelled
 		if ctx.Err() == context.Canceled {
 			downloadService.UpdateDownloadState(downloadID, models.DownloadStateError, "Download cancelled")
-			broadcastDownloadMessage(DownloadMessage{
+			broadcastDownloadMessage(owner, DownloadMessage{
 				Event:      DownloadEventError,
 				DownloadID: downloadID,
 				Data:       "Download cancelled",
@@ -157,7 +236,7 @@ func startDownloadTaskWS(downloadID uint, commandArgs []string) {
 			downloadService.UpdateDownloadState(downloadID, models.DownloadStateSuccess, "")
 			utils.ExecuteCommandBg(utils.UserConfig.Hooks.OnDownloadComplete)
 			// Broadcast success message
-			broadcastDownloadMessage(DownloadMessage{
+			broadcastDownloadMessage(owner, DownloadMessage{
 				Event:      DownloadEventSuccess,
 				DownloadID: downloadID,
 				Data:       "Download completed successfully",
@@ -167,30 +246,38 @@ func startDownloadTaskWS(downloadID uint, commandArgs []string) {
 			utils.ExecuteCommandBg(utils.UserConfig.Hooks.OnError)
 
 			// Broadcast error message
-			broadcastDownloadMessage(DownloadMessage{
+			broadcastDownloadMessage(owner, DownloadMessage{
 				Event:      DownloadEventError,
 				DownloadID: downloadID,
 				Data:       "Download failed",
 			})
 		}
 
+		// the files of the download are complete, the sort and the watcher can pick them up
+		downloadManager.ReleaseDownloadOutputs(downloadID)
+
+		// keep the playlist order before the download file names are gone
+		importPlaylistManifest(downloadID)
+
 		// Post-process: sort downloads if configured
 		if utils.UserConfig.SortAfterDownload {
 			fmt.Printf("Sorting downloads directory %s\n", utils.UserConfig.DownloadDir)
-			_, err := SortDownloadsDirectory()
+			_, err := SortDownloadsDirectory(false)
 			if err != nil {
 				utils.ExecuteCommandBg(utils.UserConfig.Hooks.OnError)
 				fmt.Println("Failed to sort downloads after download:", err.Error())
 			}
-		}
 
-		// Delete cover.jpg if one has been downloaded
-		coverPath := utils.UserConfig.DownloadDir + "/cover.jpg"
-		if _, err := os.Stat(coverPath); err == nil {
-			err := os.Remove(coverPath)
-			if err != nil {
-				fmt.Printf("Failed to delete cover.jpg: %s\n", err.Error())
+			// sorting keeps the covers it could use, delete a leftover cover.jpg so it doesn't end up on the next album
+			coverPath := utils.UserConfig.DownloadDir + "/cover.jpg"
+			if _, err := os.Stat(coverPath); err == nil {
+				err := os.Remove(coverPath)
+				if err != nil {
+					fmt.Printf("Failed to delete cover.jpg: %s\n", err.Error())
+				}
 			}
+		} else {
+			refreshMediaServers(writePlaylists())
 		}
 	}()
 
@@ -228,7 +315,7 @@ func startDownloadTaskWS(downloadID uint, commandArgs []string) {
 	downloadService.UpdateDownloadState(downloadID, models.DownloadStateProgress, "")
 
 	// Broadcast start message
-	broadcastDownloadMessage(DownloadMessage{
+	broadcastDownloadMessage(owner, DownloadMessage{
 		Event:      DownloadEventStart,
 		DownloadID: downloadID,
 		Data:       "Download started",
@@ -241,8 +328,10 @@ func startDownloadTaskWS(downloadID uint, commandArgs []string) {
 			line := scanner.Text()
 			fmt.Printf("STDOUT: %s\n", line)
 
+			downloadManager.RecordDownloadOutput(downloadID, line)
+
 			// Broadcast progress update
-			broadcastDownloadMessage(DownloadMessage{
+			broadcastDownloadMessage(owner, DownloadMessage{
 				Event:      DownloadEventProgress,
 				DownloadID: downloadID,
 				Data:       line,
@@ -265,7 +354,7 @@ func startDownloadTaskWS(downloadID uint, commandArgs []string) {
 			}
 
 			// Broadcast error output
-			broadcastDownloadMessage(DownloadMessage{
+			broadcastDownloadMessage(owner, DownloadMessage{
 				Event:      DownloadEventError,
 				DownloadID: downloadID,
 				Data:       line,
@@ -281,16 +370,51 @@ func startDownloadTaskWS(downloadID uint, commandArgs []string) {
 	}
 }
 
+// yt-dlp searches, "ytsearch:query", "ytsearch5:query", "scsearchall:query"...
+var downloadSearchRegex = regexp.MustCompile(`^(ytsearch|ytsearchdate|scsearch|bilisearch|nicosearch)(\d+|all)?:\S`)
+
+// http(s) urls and the yt-dlp searches above, anything else could be read as an option or a local file
+func isDownloadURL(value string) bool {
+	if downloadSearchRegex.MatchString(value) {
+		return true
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
 // DownloadHandler handles download requests with WebSocket streaming
 func DownloadHandler(ctx context.Context, input *struct {
 	Body struct {
 		Url       string `required:"true" json:"url"`
-		YtDlpArgs string `required:"false" example:"--arg arg_value --second-arg --third-arg" doc:"Pass additional args to yt-dlp" json:"yt_dlp_args"`
+		YtDlpArgs string `required:"false" example:"--arg arg_value --second-arg --third-arg" doc:"Pass additional args to yt-dlp, admins only" json:"yt_dlp_args"`
 	}
 }) (*DownloadResponse, error) {
+	// raw args can write anywhere on the disk or run commands (--exec)
+	if input.Body.YtDlpArgs != "" && requestRole(ctx) != utils.RoleAdmin {
+		return nil, huma.Error403Forbidden("Only admins can pass yt-dlp args")
+	}
+
+	if !isDownloadURL(input.Body.Url) {
+		return nil, huma.Error422UnprocessableEntity("The url must be an http or https url or a yt-dlp search (ytsearch:, scsearch:...)")
+	}
+
+	// yt-dlp fails halfway with cryptic errors on a full disk
+	for _, dir := range append([]string{utils.UserConfig.DownloadDir}, utils.OutputRootDirs()...) {
+		if err := utils.EnsureFreeSpace(dir, 0); err != nil {
+			if errors.Is(err, utils.ErrInsufficientSpace) {
+				return nil, huma.NewError(http.StatusInsufficientStorage, "Download refused: "+err.Error())
+			}
+			return nil, huma.Error500InternalServerError("Failed to check free disk space: " + err.Error())
+		}
+	}
+
 	// 1. Create download record in database
 	downloadService := services.NewDownloadService()
-	download, err := downloadService.CreateDownload(input.Body.Url)
+	download, err := downloadService.CreateDownload(input.Body.Url, requestUsername(ctx))
 	if err != nil {
 		return nil, huma.Error500InternalServerError("Failed to create download record")
 	}
@@ -345,14 +469,17 @@ func DownloadHandler(ctx context.Context, input *struct {
 		downloadCommandArgs = append(downloadCommandArgs, ytDlpBaseCommand...)
 	}
 
+	// record the position of each file in its playlist, to write the playlist once sorted
+	downloadCommandArgs = append(downloadCommandArgs, playlistManifestArgs(download.ID)...)
+
 	// add additional args from request
 	downloadCommandArgs = append(downloadCommandArgs, additionalArgs...)
 
-	// finally add the url
-	downloadCommandArgs = append(downloadCommandArgs, input.Body.Url)
+	// finally add the url, after "--" so it is never parsed as an option
+	downloadCommandArgs = append(downloadCommandArgs, "--", input.Body.Url)
 
 	// Start the download task in a separate goroutine so we don't block
-	go startDownloadTaskWS(download.ID, downloadCommandArgs)
+	go startDownloadTaskWS(download.ID, download.Username, downloadCommandArgs)
 
 	fmt.Printf("Download started for: %s to %s\n", input.Body.Url, utils.UserConfig.DownloadDir)
 
@@ -372,10 +499,18 @@ type GetDownloadsResponseBody struct {
 	Downloads []models.Download `json:"downloads"`
 }
 
-// GetDownloadsHandler returns all downloads from the database
+// GetDownloadsHandler returns the downloads of the current user, every download for admins
 func GetDownloadsHandler(ctx context.Context, input *struct{}) (*GetDownloadsResponse, error) {
 	downloadService := services.NewDownloadService()
-	downloads, err := downloadService.GetAllDownloads()
+
+	var downloads []models.Download
+	var err error
+
+	if requestRole(ctx) == utils.RoleAdmin {
+		downloads, err = downloadService.GetAllDownloads()
+	} else {
+		downloads, err = downloadService.GetUserDownloads(requestUsername(ctx))
+	}
 	if err != nil {
 		return nil, huma.Error500InternalServerError("Failed to get downloads: " + err.Error())
 	}
@@ -387,9 +522,31 @@ func GetDownloadsHandler(ctx context.Context, input *struct{}) (*GetDownloadsRes
 	}, nil
 }
 
+// returns the download when the current user can access it
+// downloads of other users are reported as not found
+func getAccessibleDownload(ctx context.Context, id uint) (*models.Download, error) {
+	download, err := services.NewDownloadService().GetDownload(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, huma.Error404NotFound("Download not found")
+	}
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get download: " + err.Error())
+	}
+
+	if !canAccessDownload(requestUsername(ctx), download.Username) {
+		return nil, huma.Error404NotFound("Download not found")
+	}
+
+	return download, nil
+}
+
 func DeleteDownloadHandler(ctx context.Context, input *struct {
 	ID uint `required:"true" path:"id"`
 }) (*struct{}, error) {
+	if _, err := getAccessibleDownload(ctx, input.ID); err != nil {
+		return nil, err
+	}
+
 	downloadService := services.NewDownloadService()
 
 	err := downloadService.DeleteDownload(input.ID)
@@ -403,6 +560,10 @@ func DeleteDownloadHandler(ctx context.Context, input *struct {
 func CancelDownloadHandler(ctx context.Context, input *struct {
 	ID uint `required:"true" path:"id"`
 }) (*struct{}, error) {
+	if _, err := getAccessibleDownload(ctx, input.ID); err != nil {
+		return nil, err
+	}
+
 	if downloadManager.CancelDownload(input.ID) {
 		return nil, nil
 	}
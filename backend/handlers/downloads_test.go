@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nicolassutter/scyd/utils"
+)
+
+func TestIsDownloadURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", true},
+		{"http://soundcloud.com/artist/track", true},
+		{"HTTPS://example.com/track", true},
+		{"--exec=touch /tmp/pwned", false},
+		{"-U", false},
+		{"file:///etc/passwd", false},
+		{"ytsearch:song", true},
+		{"ytsearch5:artist song", true},
+		{"scsearchall:song", true},
+		{"ytsearch:", false},
+		// the whole input is a single argument after "--", the query is never read as an option
+		{"ytsearch:--exec=id", true},
+		{"gvsearch:song", false},
+		{"https://", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := isDownloadURL(test.url); got != test.want {
+			t.Errorf("isDownloadURL(%q) = %v, want %v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestIsDownloadOutput(t *testing.T) {
+	previousDownloadDir := utils.UserConfig.DownloadDir
+	t.Cleanup(func() { utils.UserConfig.DownloadDir = previousDownloadDir })
+	utils.UserConfig.DownloadDir = "/downloads"
+
+	manager := &DownloadManager{downloads: map[uint]context.CancelFunc{}, outputs: map[uint][]string{}}
+	manager.StoreDownload(1, func() {})
+
+	for _, line := range []string{
+		"[youtube] Extracting URL: https://www.youtube.com/watch?v=abc",
+		"[download] Destination: /downloads/Artist - Song - [youtube] [abc].f251.webm",
+		`[Merger] Merging formats into "/downloads/Other - [soundcloud] [123].mkv"`,
+		"[download] /downloads/custom name.mp3 has already been downloaded",
+	} {
+		manager.RecordDownloadOutput(1, line)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/downloads/Artist - Song - [youtube] [abc].f251.webm", true},
+		{"/downloads/Artist - Song - [youtube] [abc].mp3", true},
+		{"/downloads/Artist - Song - [youtube] [abc].info.json", true},
+		{"/downloads/Other - [soundcloud] [123].mp3", true},
+		{"/downloads/custom name.mp3", true},
+		{"/downloads/custom name.mp3.part", true},
+		{"/downloads/Artist - Song - [youtube] [abcd].mp3", false},
+		{"/downloads/dropped by hand.mp3", false},
+	}
+
+	for _, test := range tests {
+		if got := manager.IsDownloadOutput(test.path); got != test.want {
+			t.Errorf("IsDownloadOutput(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+
+	manager.ReleaseDownloadOutputs(1)
+
+	if manager.IsDownloadOutput("/downloads/Artist - Song - [youtube] [abc].mp3") {
+		t.Error("the files of a completed download still belong to it")
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/dhowden/tag"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+// "(Official Video)", "[Lyrics]", "(HD)"... added to titles by uploaders
+var titleNoiseRegex = regexp.MustCompile(`(?i)\s*[(\[][^)\]]*\b(official|video|audio|lyrics?|visuali[sz]er|hd|hq|4k|mv|m/v)\b[^)\]]*[)\]]`)
+
+// guesses the artist and title of a download from its tags
+// YouTube uploads often have the channel as artist and "Artist - Song (Official Video)" as title,
+// the title is only split when the artist is such a channel so "Song - Radio Edit" keeps its artist
+func guessArtistTitle(metadata tag.Metadata, uploader string) (string, string) {
+	tagArtist := strings.TrimSpace(metadata.Artist())
+	artist := strings.TrimSuffix(tagArtist, " - Topic")
+	title := strings.TrimSpace(metadata.Title())
+
+	if titleArtist, titleTitle, found := strings.Cut(title, " - "); found {
+		switch {
+		// the title repeats the artist
+		case normalizeForMatch(artist) != "" && strings.Contains(normalizeForMatch(titleArtist), normalizeForMatch(artist)):
+			title = titleTitle
+		// the artist tag is missing or is the channel that uploaded the video
+		// "Artist - Topic" channels are named after the artist, their titles are the song alone
+		case artist == "" || (artist == tagArtist && strings.EqualFold(tagArtist, strings.TrimSpace(uploader))):
+			artist, title = titleArtist, titleTitle
+		}
+	}
+
+	return strings.TrimSpace(artist), strings.TrimSpace(titleNoiseRegex.ReplaceAllString(title, ""))
+}
+
+// lowercase letters and digits only, to compare names regardless of punctuation
+func normalizeForMatch(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, value)
+}
+
+// lowers the score of a search result whose title or artist differ from the query
+func matchConfidence(artist string, title string, match utils.MusicBrainzMatch) int {
+	confidence := match.Score
+
+	if normalizeForMatch(match.Title) != normalizeForMatch(title) {
+		confidence -= 20
+	}
+	if artist != "" && normalizeForMatch(match.Artist) != normalizeForMatch(artist) {
+		confidence -= 20
+	}
+
+	return max(confidence, 0)
+}
+
+// looks the file up by fingerprint when an AcoustID key is configured, then by its tags
+// uploader is the channel the file was downloaded from, empty when unknown
+func lookupTagMatch(path string, metadata tag.Metadata, uploader string) (*models.TagMatch, error) {
+	artist, title := guessArtistTitle(metadata, uploader)
+
+	tagMatch := &models.TagMatch{
+		Path:           path,
+		State:          models.TagMatchStateNoMatch,
+		OriginalArtist: metadata.Artist(),
+		OriginalTitle:  metadata.Title(),
+	}
+
+	var match *utils.MusicBrainzMatch
+
+	if utils.UserConfig.Enrichment.AcoustIDKey != "" {
+		recordingID, score, err := utils.LookupAcoustID(path)
+
+		if err == nil && recordingID != "" {
+			match, err = utils.GetMusicBrainzRecording(recordingID)
+			if err == nil {
+				match.Score = score
+				tagMatch.Method = "acoustid"
+			}
+		}
+
+		if err != nil && !errors.Is(err, utils.ErrFpcalcNotFound) {
+			log.Printf("Failed to fingerprint %s: %v", path, err)
+		}
+	}
+
+	if match == nil && title != "" {
+		matches, err := utils.SearchMusicBrainzRecordings(artist, title)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, candidate := range matches {
+			candidate.Score = matchConfidence(artist, title, candidate)
+			if match == nil || candidate.Score > match.Score {
+				match = &candidate
+			}
+		}
+		tagMatch.Method = "musicbrainz"
+	}
+
+	if match == nil {
+		return tagMatch, nil
+	}
+
+	tagMatch.State = models.TagMatchStatePending
+	if match.Score >= utils.UserConfig.Enrichment.MinConfidence {
+		tagMatch.State = models.TagMatchStateApplied
+	}
+
+	tagMatch.Confidence = match.Score
+	tagMatch.Title = match.Title
+	tagMatch.Artist = match.Artist
+	tagMatch.AlbumArtist = match.AlbumArtist
+	tagMatch.Album = match.Album
+	tagMatch.TrackNumber = match.TrackNumber
+	tagMatch.DiscNumber = match.DiscNumber
+	tagMatch.Year = match.Year
+	tagMatch.RecordingID = match.RecordingID
+	tagMatch.ReleaseID = match.ReleaseID
+	tagMatch.ArtistID = match.ArtistID
+
+	return tagMatch, nil
+}
+
+// the tags written for a match, empty values of the match keep the current tags
+func tagMatchUpdate(match *models.TagMatch) TrackTagsUpdate {
+	update := TrackTagsUpdate{
+		Title:  &match.Title,
+		Artist: &match.Artist,
+	}
+
+	if match.Album != "" {
+		update.Album = &match.Album
+		update.AlbumArtist = &match.AlbumArtist
+		update.MusicBrainzReleaseID = &match.ReleaseID
+	}
+	if match.TrackNumber > 0 {
+		update.TrackNumber = &match.TrackNumber
+	}
+	if match.DiscNumber > 0 {
+		update.DiscNumber = &match.DiscNumber
+	}
+	if match.Year > 0 {
+		update.Year = &match.Year
+	}
+	if match.RecordingID != "" {
+		update.MusicBrainzRecordingID = &match.RecordingID
+	}
+	if match.ArtistID != "" {
+		update.MusicBrainzArtistID = &match.ArtistID
+	}
+
+	return update
+}
+
+// reports whether the entry is looked up on MusicBrainz before being moved
+// files are looked up once, those with MusicBrainz tags never
+func needsEnrichment(entry *SortPlanEntry, tagMatchService *services.TagMatchService) bool {
+	if entry.AlreadySorted || !utils.IsTagWritable(entry.Source) {
+		return false
+	}
+
+	return utils.MusicBrainzRecordingID(entry.group.Metadata) == "" && !tagMatchService.HasMatch(entry.Source)
+}
+
+// flags the entries of a dry run whose tags, and so destination, may still change when they are looked up
+// dry runs don't query MusicBrainz, a lookup is saved and can write tags
+func markPendingEnrichment(plan []*SortPlanEntry) {
+	if !utils.UserConfig.Enrichment.Enabled {
+		return
+	}
+
+	tagMatchService := services.NewTagMatchService()
+
+	for _, entry := range plan {
+		entry.PendingEnrichment = needsEnrichment(entry, tagMatchService)
+	}
+}
+
+// looks up the downloads about to be sorted and writes the tags of confident matches
+// files are looked up once, low confidence matches wait in the review queue
+// returns true when tags were written and the plan has to be built again
+func enrichSortPlan(plan []*SortPlanEntry) bool {
+	if !utils.UserConfig.Enrichment.Enabled {
+		return false
+	}
+
+	tagMatchService := services.NewTagMatchService()
+	changed := false
+
+	for _, entry := range plan {
+		if !needsEnrichment(entry, tagMatchService) {
+			continue
+		}
+
+		tagMatch, err := lookupTagMatch(entry.Source, entry.group.Metadata, infoJSONUploader(entry.group))
+		if err != nil {
+			// MusicBrainz is unreachable, the file will be looked up on the next sort
+			log.Printf("Failed to look up %s on MusicBrainz: %v", entry.Source, err)
+			continue
+		}
+
+		if tagMatch.State == models.TagMatchStateApplied {
+			err := utils.WriteTags(entry.Source, tagMatchUpdate(tagMatch).tagUpdate())
+
+			if err == nil {
+				var metadata tag.Metadata
+				if metadata, err = utils.GetMetadataFromFile(entry.Source); err == nil {
+					entry.group.Metadata = metadata
+				}
+			}
+
+			if err != nil {
+				log.Printf("Failed to write MusicBrainz tags to %s: %v", entry.Source, err)
+				tagMatch.State = models.TagMatchStatePending
+			} else {
+				changed = true
+			}
+		}
+
+		if err := tagMatchService.CreateMatch(tagMatch); err != nil {
+			log.Printf("Failed to save MusicBrainz match of %s: %v", entry.Source, err)
+		}
+	}
+
+	return changed
+}
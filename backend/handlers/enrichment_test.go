@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dhowden/tag"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// tags of a download, only what the enrichment reads
+type stubMetadata struct {
+	tag.Metadata
+	artist string
+	title  string
+	raw    map[string]any
+}
+
+func (m stubMetadata) Artist() string      { return m.artist }
+func (m stubMetadata) Title() string       { return m.title }
+func (m stubMetadata) Raw() map[string]any { return m.raw }
+
+func TestGuessArtistTitle(t *testing.T) {
+	tests := []struct {
+		name       string
+		artist     string
+		title      string
+		uploader   string
+		wantArtist string
+		wantTitle  string
+	}{
+		{"artist tag, title without artist", "Band", "Song - Radio Edit", "", "Band", "Song - Radio Edit"},
+		{"title repeats the artist", "Band", "Band - Song (Official Video)", "", "Band", "Song"},
+		{"title repeats the artist with a guest", "Band", "Band feat. Guest - Song [Lyrics]", "Band", "Band", "Song"},
+		{"topic channel", "Band - Topic", "Song - Radio Edit", "Band - Topic", "Band", "Song - Radio Edit"},
+		{"uploader is the artist tag", "Channel", "Band - Song (Official Audio)", "Channel", "Band", "Song"},
+		{"uploader differs in case", "channel", "Band - Song", "Channel", "Band", "Song"},
+		{"no artist tag", "", "Band - Song (HD)", "", "Band", "Song"},
+		{"label without uploader", "Label", "Song - Extended Mix", "", "Label", "Song - Extended Mix"},
+		{"uploader differs from the artist tag", "Label", "Song - Extended Mix", "Channel", "Label", "Song - Extended Mix"},
+		{"no separator", "Channel", "Song (Official Video)", "Channel", "Channel", "Song"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			artist, title := guessArtistTitle(stubMetadata{artist: test.artist, title: test.title}, test.uploader)
+			if artist != test.wantArtist || title != test.wantTitle {
+				t.Errorf("guessArtistTitle(%q, %q, %q) = %q, %q, want %q, %q",
+					test.artist, test.title, test.uploader, artist, title, test.wantArtist, test.wantTitle)
+			}
+		})
+	}
+}
+
+func TestInfoJSONUploader(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name     string
+		sidecars []string
+		want     string
+	}{
+		{"info.json", []string{write("song.lrc", "[00:00.00]"), write("song.info.json", `{"uploader": "Channel", "title": "Band - Song"}`)}, "Channel"},
+		{"no uploader", []string{write("other.info.json", `{"title": "Band - Song"}`)}, ""},
+		{"invalid json", []string{write("broken.info.json", `{"uploader":`)}, ""},
+		{"no info.json", []string{write("cover.jpg", "")}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := infoJSONUploader(&sortGroup{Sidecars: test.sidecars}); got != test.want {
+				t.Errorf("infoJSONUploader(%v) = %q, want %q", test.sidecars, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMarkPendingEnrichment(t *testing.T) {
+	previousDB := utils.DB
+	previousEnrichment := utils.UserConfig.Enrichment
+	t.Cleanup(func() {
+		utils.DB = previousDB
+		utils.UserConfig.Enrichment = previousEnrichment
+	})
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "scyd.db")), &gorm.Config{})
+	if err == nil {
+		err = db.AutoMigrate(&models.TagMatch{})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.DB = db
+
+	if err := db.Create(&models.TagMatch{Path: "/downloads/looked up.mp3", State: models.TagMatchStateNoMatch}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	entry := func(source string, alreadySorted bool, raw map[string]any) *SortPlanEntry {
+		return &SortPlanEntry{
+			SortPlanMove:  SortPlanMove{Source: source},
+			AlreadySorted: alreadySorted,
+			group:         &sortGroup{AudioPath: source, Metadata: stubMetadata{raw: raw}},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		entry *SortPlanEntry
+		want  bool
+	}{
+		{"never looked up", entry("/downloads/new.mp3", false, nil), true},
+		{"already looked up", entry("/downloads/looked up.mp3", false, nil), false},
+		{"musicbrainz tags", entry("/downloads/tagged.flac", false, map[string]any{"musicbrainz_trackid": "rec-1"}), false},
+		{"already sorted", entry("/downloads/sorted.mp3", true, nil), false},
+		{"tags not writable", entry("/downloads/song.wav", false, nil), false},
+	}
+
+	plan := []*SortPlanEntry{}
+	for _, test := range tests {
+		plan = append(plan, test.entry)
+	}
+
+	utils.UserConfig.Enrichment.Enabled = false
+	markPendingEnrichment(plan)
+
+	for _, test := range tests {
+		if test.entry.PendingEnrichment {
+			t.Errorf("%s: pending enrichment while the enrichment is disabled", test.name)
+		}
+	}
+
+	utils.UserConfig.Enrichment.Enabled = true
+	markPendingEnrichment(plan)
+
+	for _, test := range tests {
+		if test.entry.PendingEnrichment != test.want {
+			t.Errorf("%s: pending enrichment = %v, want %v", test.name, test.entry.PendingEnrichment, test.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dhowden/tag"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+// matches the yt-dlp output template suffix: "title - [extractor] [id]"
+var downloadFileNameRegex = regexp.MustCompile(`^(.*?)\s*-\s*\[([^\]]+)\]\s*\[([^\]]+)\]$`)
+
+// where a file was downloaded from, as encoded in its download file name
+type SortProvenance struct {
+	Extractor string `json:"extractor"`
+	ID        string `json:"id"`
+}
+
+// splits a download file name (without extension) into its readable part and its provenance
+// provenance is nil when the name doesn't follow the download output template
+func parseDownloadFileName(stem string) (string, *SortProvenance) {
+	matches := downloadFileNameRegex.FindStringSubmatch(stem)
+	if matches == nil {
+		return stem, nil
+	}
+
+	return matches[1], &SortProvenance{Extractor: matches[2], ID: matches[3]}
+}
+
+// returns the path of a download output without its extensions, "/dl/Song - [youtube] [id]"
+// for "/dl/Song - [youtube] [id].f251.webm", false when the name doesn't follow the output template
+func downloadOutputStem(path string) (string, bool) {
+	for stem := path; filepath.Ext(stem) != ""; {
+		stem = strings.TrimSuffix(stem, filepath.Ext(stem))
+		if _, provenance := parseDownloadFileName(filepath.Base(stem)); provenance != nil {
+			return stem, true
+		}
+	}
+	return "", false
+}
+
+// builds the library file name (without extension) of an audio file from its tags:
+// "02 - Title", "1-02 - Title" for multi disc albums or "Title" without track number
+func buildTrackFileStem(metadata tag.Metadata, originalStem string) string {
+	title := sanitizePathComponent(metadata.Title())
+
+	if title == "" {
+		cleanStem, _ := parseDownloadFileName(originalStem)
+		title = sanitizePathComponent(cleanStem)
+	}
+
+	if title == "" {
+		return originalStem
+	}
+
+	trackNumber, _ := metadata.Track()
+	discNumber, discTotal := metadata.Disc()
+
+	switch {
+	case trackNumber > 0 && discTotal > 1 && discNumber > 0:
+		return fmt.Sprintf("%d-%02d - %s", discNumber, trackNumber, title)
+	case trackNumber > 0:
+		return fmt.Sprintf("%02d - %s", trackNumber, title)
+	default:
+		return title
+	}
+}
+
+// returns the name an audio file gets in the library, without extension
+func planFileStem(group *sortGroup) string {
+	audioName := filepath.Base(group.AudioPath)
+	oldStem := strings.TrimSuffix(audioName, filepath.Ext(audioName))
+
+	if utils.UserConfig.RenameFiles {
+		return buildTrackFileStem(group.Metadata, oldStem)
+	}
+	return oldStem
+}
+
+// returns the new name of each sidecar of an audio file renamed to newStem
+// sidecars keep whatever follows the audio base name, like ".en.lrc" or ".info.json"
+func planSidecarNames(group *sortGroup, newStem string) map[string]string {
+	audioName := filepath.Base(group.AudioPath)
+	oldStem := strings.TrimSuffix(audioName, filepath.Ext(audioName))
+
+	sidecarNames := map[string]string{}
+	for _, sidecar := range group.Sidecars {
+		sidecarName := filepath.Base(sidecar)
+		sidecarNames[sidecar] = newStem + strings.TrimPrefix(sidecarName, oldStem)
+	}
+
+	return sidecarNames
+}
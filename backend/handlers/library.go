@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+type PaginationParams struct {
+	Page     int `query:"page" default:"1" minimum:"1"`
+	PageSize int `query:"page_size" default:"50" minimum:"1" maximum:"500"`
+}
+
+func (p PaginationParams) toPagination() services.Pagination {
+	return services.Pagination{Page: p.Page, PageSize: p.PageSize}
+}
+
+type PaginationBody struct {
+	Total    int64 `json:"total"`
+	Page     int   `json:"page"`
+	PageSize int   `json:"page_size"`
+}
+
+func newPaginationBody(params PaginationParams, total int64) PaginationBody {
+	return PaginationBody{Total: total, Page: params.Page, PageSize: params.PageSize}
+}
+
+// asks the media servers to rescan the directories of the given files
+func refreshMediaServers(paths []string) {
+	dirs := []string{}
+	for _, path := range paths {
+		dirs = append(dirs, filepath.Dir(path))
+	}
+
+	utils.RefreshMediaServers(dirs)
+}
+
+// updates the library index after files were added to or removed from the output dir
+// the media servers are refreshed by the caller, once with the playlists rewritten after it
+func indexLibraryFiles(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+
+	if err := services.NewLibraryService().IndexFiles(paths); err != nil {
+		log.Printf("Failed to update library index: %v", err)
+	}
+}
+
+// ScanLibraryInBackground runs a full library scan without blocking the caller
+func ScanLibraryInBackground() {
+	go func() {
+		result, err := services.NewLibraryService().ScanLibrary()
+		if err != nil {
+			log.Printf("Failed to scan library: %v", err)
+			return
+		}
+
+		log.Printf("Library scan done: %d indexed, %d unchanged, %d removed", result.Indexed, result.Unchanged, result.Removed)
+	}()
+}
+
+type ScanLibraryResponse struct {
+	Body *services.LibraryScanResult
+}
+
+// ScanLibraryHandler runs a full scan of the output dir
+func ScanLibraryHandler(ctx context.Context, input *struct{}) (*ScanLibraryResponse, error) {
+	result, err := services.NewLibraryService().ScanLibrary()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to scan library: " + err.Error())
+	}
+
+	return &ScanLibraryResponse{Body: result}, nil
+}
+
+type GetLibraryTracksResponse struct {
+	Body struct {
+		PaginationBody
+		Tracks []models.Track `json:"tracks"`
+	}
+}
+
+func GetLibraryTracksHandler(ctx context.Context, input *struct {
+	PaginationParams
+	Query    string `query:"q" doc:"Search in track titles"`
+	ArtistID uint   `query:"artist_id"`
+	AlbumID  uint   `query:"album_id"`
+	Artist   string `query:"artist" doc:"Search in artist names"`
+	Album    string `query:"album" doc:"Search in album titles"`
+	Genre    string `query:"genre"`
+	Year     int    `query:"year"`
+}) (*GetLibraryTracksResponse, error) {
+	tracks, total, err := services.NewLibraryService().GetTracks(services.TrackFilter{
+		Query:    input.Query,
+		ArtistID: input.ArtistID,
+		AlbumID:  input.AlbumID,
+		Artist:   input.Artist,
+		Album:    input.Album,
+		Genre:    input.Genre,
+		Year:     input.Year,
+	}, input.toPagination())
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get tracks: " + err.Error())
+	}
+
+	response := &GetLibraryTracksResponse{}
+	response.Body.PaginationBody = newPaginationBody(input.PaginationParams, total)
+	response.Body.Tracks = tracks
+
+	return response, nil
+}
+
+func getLibraryTrack(id uint) (*models.Track, error) {
+	track, err := services.NewLibraryService().GetTrack(id)
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, huma.Error404NotFound("Track not found")
+	}
+
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get track: " + err.Error())
+	}
+
+	return track, nil
+}
+
+type GetLibraryTrackResponse struct {
+	Body *models.Track
+}
+
+func GetLibraryTrackHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*GetLibraryTrackResponse, error) {
+	track, err := getLibraryTrack(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetLibraryTrackResponse{Body: track}, nil
+}
+
+type GetLibraryAlbumsResponse struct {
+	Body struct {
+		PaginationBody
+		Albums []models.Album `json:"albums"`
+	}
+}
+
+func GetLibraryAlbumsHandler(ctx context.Context, input *struct {
+	PaginationParams
+	Query    string `query:"q" doc:"Search in album titles"`
+	ArtistID uint   `query:"artist_id"`
+	Artist   string `query:"artist" doc:"Search in album artist names"`
+	Genre    string `query:"genre"`
+	Year     int    `query:"year"`
+}) (*GetLibraryAlbumsResponse, error) {
+	albums, total, err := services.NewLibraryService().GetAlbums(services.AlbumFilter{
+		Query:    input.Query,
+		ArtistID: input.ArtistID,
+		Artist:   input.Artist,
+		Genre:    input.Genre,
+		Year:     input.Year,
+	}, input.toPagination())
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get albums: " + err.Error())
+	}
+
+	response := &GetLibraryAlbumsResponse{}
+	response.Body.PaginationBody = newPaginationBody(input.PaginationParams, total)
+	response.Body.Albums = albums
+
+	return response, nil
+}
+
+type GetLibraryArtistsResponse struct {
+	Body struct {
+		PaginationBody
+		Artists []models.Artist `json:"artists"`
+	}
+}
+
+func GetLibraryArtistsHandler(ctx context.Context, input *struct {
+	PaginationParams
+	Query string `query:"q" doc:"Search in artist names"`
+}) (*GetLibraryArtistsResponse, error) {
+	artists, total, err := services.NewLibraryService().GetArtists(input.Query, input.toPagination())
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get artists: " + err.Error())
+	}
+
+	response := &GetLibraryArtistsResponse{}
+	response.Body.PaginationBody = newPaginationBody(input.PaginationParams, total)
+	response.Body.Artists = artists
+
+	return response, nil
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+type HealthTrack struct {
+	ID     uint   `json:"id"`
+	Path   string `json:"path"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	// tell duplicates apart, zero when unknown
+	Duration float64 `json:"duration" doc:"In seconds"`
+	Bitrate  int     `json:"bitrate" doc:"In bit/s"`
+	Codec    string  `json:"codec"`
+}
+
+func newHealthTracks(tracks []models.Track) []HealthTrack {
+	healthTracks := make([]HealthTrack, 0, len(tracks))
+
+	for _, track := range tracks {
+		healthTrack := HealthTrack{
+			ID:       track.ID,
+			Path:     track.Path,
+			Title:    track.Title,
+			Duration: track.Duration,
+			Bitrate:  track.Bitrate,
+			Codec:    track.Codec,
+		}
+		if track.Artist != nil {
+			healthTrack.Artist = track.Artist.Name
+		}
+		if track.Album != nil {
+			healthTrack.Album = track.Album.Title
+		}
+		healthTracks = append(healthTracks, healthTrack)
+	}
+
+	return healthTracks
+}
+
+type LibraryHealthCounts struct {
+	MissingArtist   int `json:"missing_artist"`
+	MissingAlbum    int `json:"missing_album"`
+	MissingTitle    int `json:"missing_title"`
+	MissingCover    int `json:"missing_cover"`
+	UnreadableFiles int `json:"unreadable_files"`
+	Duplicates      int `json:"duplicates"`
+	EmptyDirs       int `json:"empty_dirs"`
+	OrphanFiles     int `json:"orphan_files"`
+}
+
+type LibraryHealthResponse struct {
+	Body LibraryHealthResponseBody
+}
+
+type LibraryHealthResponseBody struct {
+	Counts        LibraryHealthCounts `json:"counts"`
+	MissingArtist []HealthTrack       `json:"missing_artist"`
+	MissingAlbum  []HealthTrack       `json:"missing_album"`
+	MissingTitle  []HealthTrack       `json:"missing_title"`
+	MissingCover  []HealthTrack       `json:"missing_cover"`
+	// Audio files whose tags can't be read
+	UnreadableFiles []string `json:"unreadable_files"`
+	// Groups of tracks with the same artist, title and duration
+	Duplicates [][]HealthTrack `json:"duplicates"`
+	EmptyDirs  []string        `json:"empty_dirs"`
+	// Files that are neither audio, covers, playlists nor sidecars of an audio file
+	OrphanFiles []string `json:"orphan_files"`
+}
+
+type libraryFileIssues struct {
+	unreadableFiles []string
+	emptyDirs       []string
+	orphanFiles     []string
+}
+
+// walks the output roots for the files the library index doesn't know about
+func findLibraryFileIssues() (*libraryFileIssues, error) {
+	trackPaths, err := services.NewLibraryService().GetTrackPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	roots := utils.OutputRootDirs()
+	issues := &libraryFileIssues{
+		unreadableFiles: []string{},
+		emptyDirs:       []string{},
+		orphanFiles:     []string{},
+	}
+
+	for _, root := range roots {
+		err = filepath.WalkDir(root, func(dir string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.IsDir() {
+				return nil
+			}
+
+			// hidden directories hold scyd's own data, nested output roots are walked on their own
+			if dir != root && (strings.HasPrefix(d.Name(), ".") || slices.Contains(roots, dir)) {
+				return filepath.SkipDir
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+
+			if len(entries) == 0 && dir != root {
+				issues.emptyDirs = append(issues.emptyDirs, dir)
+				return nil
+			}
+
+			audioStems := []string{}
+			others := []string{}
+
+			for _, entry := range entries {
+				name := entry.Name()
+				if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+					continue
+				}
+
+				ext := strings.ToLower(filepath.Ext(name))
+				_, isAudio := audioContentTypes[ext]
+
+				switch {
+				case trackPaths[filepath.Join(dir, name)]:
+					audioStems = append(audioStems, strings.TrimSuffix(name, filepath.Ext(name)))
+				case isAudio:
+					issues.unreadableFiles = append(issues.unreadableFiles, filepath.Join(dir, name))
+				case utils.IsCoverFileName(name) || ext == ".m3u8" || ext == ".m3u":
+				default:
+					others = append(others, name)
+				}
+			}
+
+			for _, name := range others {
+				isSidecar := false
+
+				for _, stem := range audioStems {
+					if suffix, found := strings.CutPrefix(name, stem); found && strings.HasPrefix(suffix, ".") && isRecognizedSidecar(suffix) {
+						isSidecar = true
+						break
+					}
+				}
+
+				if !isSidecar {
+					issues.orphanFiles = append(issues.orphanFiles, filepath.Join(dir, name))
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return issues, nil
+}
+
+// GetLibraryHealthHandler reports tracks with missing tags, likely duplicates and files that don't belong in the library
+func GetLibraryHealthHandler(ctx context.Context, input *struct{}) (*LibraryHealthResponse, error) {
+	trackIssues, err := services.NewLibraryService().GetTrackHealthIssues()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get library tracks: " + err.Error())
+	}
+
+	fileIssues, err := findLibraryFileIssues()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to read output directory: " + err.Error())
+	}
+
+	duplicates := make([][]HealthTrack, 0, len(trackIssues.Duplicates))
+	for _, group := range trackIssues.Duplicates {
+		duplicates = append(duplicates, newHealthTracks(group))
+	}
+
+	return &LibraryHealthResponse{
+		Body: LibraryHealthResponseBody{
+			Counts: LibraryHealthCounts{
+				MissingArtist:   len(trackIssues.MissingArtist),
+				MissingAlbum:    len(trackIssues.MissingAlbum),
+				MissingTitle:    len(trackIssues.MissingTitle),
+				MissingCover:    len(trackIssues.MissingCover),
+				UnreadableFiles: len(fileIssues.unreadableFiles),
+				Duplicates:      len(duplicates),
+				EmptyDirs:       len(fileIssues.emptyDirs),
+				OrphanFiles:     len(fileIssues.orphanFiles),
+			},
+			MissingArtist:   newHealthTracks(trackIssues.MissingArtist),
+			MissingAlbum:    newHealthTracks(trackIssues.MissingAlbum),
+			MissingTitle:    newHealthTracks(trackIssues.MissingTitle),
+			MissingCover:    newHealthTracks(trackIssues.MissingCover),
+			UnreadableFiles: fileIssues.unreadableFiles,
+			Duplicates:      duplicates,
+			EmptyDirs:       fileIssues.emptyDirs,
+			OrphanFiles:     fileIssues.orphanFiles,
+		},
+	}, nil
+}
+
+const (
+	HealthFixRemoveEmptyDirs   = "remove_empty_dirs"
+	HealthFixEmbedFolderCovers = "embed_folder_covers"
+	HealthFixTitleFromFileName = "title_from_file_name"
+)
+
+type FixLibraryHealthResponse struct {
+	Body FixLibraryHealthResponseBody
+}
+
+type FixLibraryHealthResponseBody struct {
+	// key: action, value: number of fixed directories or tracks
+	Fixed           map[string]int `json:"fixed"`
+	FilesWithErrors []string       `json:"files_with_errors"`
+}
+
+// "02 - Title" or "1-02 - Title" as written when sorting
+var trackNumberPrefixRegex = regexp.MustCompile(`^\d+(-\d+)?\s*-\s*`)
+
+// guesses the title of a track from its file name, without track number, artist or download suffix
+func titleFromFileName(track models.Track) string {
+	stem := strings.TrimSuffix(filepath.Base(track.Path), filepath.Ext(track.Path))
+	title, _ := parseDownloadFileName(stem)
+	title = trackNumberPrefixRegex.ReplaceAllString(title, "")
+
+	if track.Artist != nil && track.Artist.Name != services.UnknownArtistName {
+		title = strings.TrimPrefix(title, track.Artist.Name+" - ")
+	}
+
+	return strings.TrimSpace(title)
+}
+
+// FixLibraryHealthHandler applies bulk fixes for the issues of the health report
+func FixLibraryHealthHandler(ctx context.Context, input *struct {
+	Body struct {
+		Actions  []string `json:"actions" required:"true" minItems:"1" enum:"remove_empty_dirs,embed_folder_covers,title_from_file_name"`
+		TrackIDs []uint   `json:"track_ids,omitempty" doc:"Only fix these tracks, all tracks when omitted"`
+	}
+}) (*FixLibraryHealthResponse, error) {
+	res := &FixLibraryHealthResponse{
+		Body: FixLibraryHealthResponseBody{
+			Fixed:           map[string]int{},
+			FilesWithErrors: []string{},
+		},
+	}
+
+	trackIssues, err := services.NewLibraryService().GetTrackHealthIssues()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get library tracks: " + err.Error())
+	}
+
+	selected := func(track models.Track) bool {
+		return len(input.Body.TrackIDs) == 0 || slices.Contains(input.Body.TrackIDs, track.ID)
+	}
+
+	fixTrack := func(action string, track models.Track, update TrackTagsUpdate) {
+		if _, err := updateTrackTags(&track, update); err != nil {
+			log.Printf("Failed to fix %s of %s: %v", action, track.Path, err)
+			res.Body.FilesWithErrors = append(res.Body.FilesWithErrors, track.Path)
+			return
+		}
+		res.Body.Fixed[action]++
+	}
+
+	for _, action := range input.Body.Actions {
+		res.Body.Fixed[action] = 0
+
+		switch action {
+		case HealthFixRemoveEmptyDirs:
+			for _, root := range utils.OutputRootDirs() {
+				res.Body.Fixed[action] += len(pruneEmptyDirs(root))
+			}
+
+		case HealthFixEmbedFolderCovers:
+			for _, track := range trackIssues.MissingCover {
+				coverPath := utils.FindCoverFile(filepath.Dir(track.Path))
+				if !selected(track) || coverPath == "" {
+					continue
+				}
+
+				data, err := os.ReadFile(coverPath)
+				if err != nil {
+					log.Printf("Failed to read cover %s: %v", coverPath, err)
+					res.Body.FilesWithErrors = append(res.Body.FilesWithErrors, track.Path)
+					continue
+				}
+
+				fixTrack(action, track, TrackTagsUpdate{Cover: data})
+			}
+
+		case HealthFixTitleFromFileName:
+			for _, track := range trackIssues.MissingTitle {
+				title := titleFromFileName(track)
+				if !selected(track) || title == "" {
+					continue
+				}
+
+				fixTrack(action, track, TrackTagsUpdate{Title: &title})
+			}
+		}
+	}
+
+	return res, nil
+}
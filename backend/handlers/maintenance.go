@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+// scheduled and manual runs never overlap
+var maintenanceMutex sync.Mutex
+
+type MaintenanceReport struct {
+	// successful downloads removed from the history
+	PurgedDownloads int64 `json:"purged_downloads"`
+	// downloads deleted from the history, now removed from the database
+	PurgedDeletedDownloads int64    `json:"purged_deleted_downloads"`
+	RemovedTempFiles       []string `json:"removed_temp_files"`
+	// true when temporary files were left alone because a download was running
+	SkippedTempFiles bool `json:"skipped_temp_files"`
+	// deleted tracks and albums removed from the trash for good
+	PurgedTrashEntries int `json:"purged_trash_entries"`
+	// sort runs whose set aside download covers were removed for good
+	PurgedSortRunCovers int `json:"purged_sort_run_covers"`
+}
+
+// files left behind by an interrupted download: partial files, yt-dlp fragments and post-processing copies
+func isLeftoverTempFile(name string) bool {
+	return isPartialFile(name) || strings.Contains(name, ".part-Frag") || strings.Contains(name, ".temp.")
+}
+
+// removes the temporary files of the download dir that weren't modified for maxAge
+func removeLeftoverTempFiles(maxAge time.Duration) []string {
+	removed := []string{}
+	root := utils.UserConfig.DownloadDir
+	cutoff := time.Now().Add(-maxAge)
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		// quarantined files are kept until someone looks at them
+		if d.IsDir() && path == quarantineDir() {
+			return filepath.SkipDir
+		}
+
+		if !d.Type().IsRegular() || !isLeftoverTempFile(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove leftover file %s: %v", path, err)
+			return nil
+		}
+		removed = append(removed, path)
+
+		return nil
+	})
+
+	if len(removed) > 0 {
+		pruneEmptyDirs(root)
+	}
+
+	return removed
+}
+
+// applies the retention policies
+func runMaintenance() (*MaintenanceReport, error) {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+
+	retention := utils.UserConfig.Retention
+	report := &MaintenanceReport{RemovedTempFiles: []string{}}
+
+	var successfulBefore *time.Time
+	if retention.SuccessfulDownloadsDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -retention.SuccessfulDownloadsDays)
+		successfulBefore = &cutoff
+	}
+
+	purged, purgedDeleted, err := services.NewDownloadService().PurgeDownloads(successfulBefore)
+	report.PurgedDownloads = purged
+	report.PurgedDeletedDownloads = purgedDeleted
+	if err != nil {
+		return report, err
+	}
+
+	if retention.TempFilesHours > 0 {
+		// a running download might still be writing its oldest fragments
+		if downloadManager.HasActiveDownloads() {
+			report.SkippedTempFiles = true
+		} else {
+			report.RemovedTempFiles = removeLeftoverTempFiles(time.Duration(retention.TempFilesHours) * time.Hour)
+		}
+	}
+
+	report.PurgedTrashEntries, err = purgeExpiredTrash()
+	if err != nil {
+		return report, err
+	}
+
+	report.PurgedSortRunCovers = purgeSortRunCovers()
+
+	return report, nil
+}
+
+// covers set aside by sort runs are kept as long as the trash keeps deleted files
+// undoing an older run restores its audio files without them
+func purgeSortRunCovers() int {
+	if utils.UserConfig.Trash.RetentionDays <= 0 {
+		return 0
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -utils.UserConfig.Trash.RetentionDays)
+	dir := sortRunCoversDir()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	purged := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("Failed to purge covers of sort run %s: %v", entry.Name(), err)
+			continue
+		}
+		purged++
+	}
+
+	return purged
+}
+
+// StartMaintenanceScheduler runs the maintenance at startup and then every retention.interval_hours
+func StartMaintenanceScheduler() {
+	interval := time.Duration(utils.UserConfig.Retention.IntervalHours) * time.Hour
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			report, err := runMaintenance()
+			if err != nil {
+				log.Printf("Maintenance failed: %v", err)
+			} else {
+				log.Printf("Maintenance done: %d downloads purged, %d deleted downloads purged, %d leftover files removed, %d trash entries purged, %d sort run covers purged",
+					report.PurgedDownloads, report.PurgedDeletedDownloads, len(report.RemovedTempFiles), report.PurgedTrashEntries, report.PurgedSortRunCovers)
+			}
+
+			time.Sleep(interval)
+		}
+	}()
+}
+
+type RunMaintenanceResponse struct {
+	Body *MaintenanceReport
+}
+
+// RunMaintenanceHandler applies the retention policies now
+func RunMaintenanceHandler(ctx context.Context, input *struct{}) (*RunMaintenanceResponse, error) {
+	report, err := runMaintenance()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to run maintenance: " + err.Error())
+	}
+
+	return &RunMaintenanceResponse{Body: report}, nil
+}
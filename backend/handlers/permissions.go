@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humafiber"
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+// scopes of the protected operations, granted to users by their role and restricting API tokens
+const (
+	ScopeDownloadCreate = "download:create"
+	ScopeDownloadRead   = "download:read"
+	ScopeDownloadManage = "download:manage"
+	ScopeSort           = "sort"
+	ScopeLibraryRead    = "library:read"
+	ScopeLibraryWrite   = "library:write"
+	ScopeMaintenance    = "maintenance"
+)
+
+// scopes granted to each role, admins have all of them
+var roleScopes = map[utils.Role][]string{
+	utils.RoleUser:   {ScopeDownloadCreate, ScopeDownloadRead, ScopeDownloadManage, ScopeLibraryRead, ScopeLibraryWrite},
+	utils.RoleViewer: {ScopeDownloadRead, ScopeLibraryRead},
+}
+
+// fiber locals set by AuthMiddleware
+const (
+	localsUsername = "username"
+	localsAPIToken = "apiToken"
+)
+
+// operation metadata keys
+const (
+	metadataScope       = "scope"
+	metadataSessionOnly = "sessionOnly"
+	metadataPublic      = "public"
+)
+
+// reports whether the role can call an operation requiring the given scope
+// unknown roles, like the empty role of removed users, can't call anything
+func roleAllows(role utils.Role, scope string) bool {
+	switch role {
+	case utils.RoleAdmin:
+		return true
+	case utils.RoleUser, utils.RoleViewer:
+		return scope == "" || slices.Contains(roleScopes[role], scope)
+	default:
+		return false
+	}
+}
+
+// RequireScope marks a Huma operation as requiring the given scope
+func RequireScope(scope string) func(o *huma.Operation) {
+	return func(o *huma.Operation) {
+		if o.Metadata == nil {
+			o.Metadata = map[string]any{}
+		}
+		o.Metadata[metadataScope] = scope
+	}
+}
+
+// RequireSession marks a Huma operation as only callable with the session cookie, API tokens are refused
+func RequireSession(o *huma.Operation) {
+	if o.Metadata == nil {
+		o.Metadata = map[string]any{}
+	}
+	o.Metadata[metadataSessionOnly] = true
+}
+
+// Public marks a Huma operation as callable without authentication, every other operation requires a user
+func Public(o *huma.Operation) {
+	if o.Metadata == nil {
+		o.Metadata = map[string]any{}
+	}
+	o.Metadata[metadataPublic] = true
+}
+
+// returns the status and the reason the request can't call an operation, an empty reason when it can
+// requests without an authenticated user are refused, whatever let them through
+func requestPermissionDenial(c *fiber.Ctx, scope string, sessionOnly bool) (int, string) {
+	username, ok := c.Locals(localsUsername).(string)
+	if !ok || username == "" {
+		return http.StatusUnauthorized, "Authentication required"
+	}
+
+	token, _ := c.Locals(localsAPIToken).(*models.APIToken)
+	return http.StatusForbidden, permissionDenial(username, token, scope, sessionOnly)
+}
+
+// returns why the user can't call an operation, an empty string when they can
+// token is nil for requests authenticated with the session cookie
+func permissionDenial(username string, token *models.APIToken, scope string, sessionOnly bool) string {
+	if role, _ := utils.GetUserRole(username); !roleAllows(role, scope) {
+		return "Your role doesn't allow this operation"
+	}
+
+	if token == nil {
+		return ""
+	}
+
+	if sessionOnly {
+		return "API tokens can't be used for this operation"
+	}
+	if !tokenAllows(token, scope) {
+		return "API token is missing the required scope"
+	}
+
+	return ""
+}
+
+// CheckPermissions refuses the Huma operations the role of the user or the API token of the request don't allow
+// operations not marked Public require an authenticated user
+func CheckPermissions(api huma.API) func(ctx huma.Context, next func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		metadata := ctx.Operation().Metadata
+		if public, _ := metadata[metadataPublic].(bool); public {
+			next(ctx)
+			return
+		}
+
+		scope, _ := metadata[metadataScope].(string)
+		sessionOnly, _ := metadata[metadataSessionOnly].(bool)
+
+		if status, denial := requestPermissionDenial(humafiber.Unwrap(ctx), scope, sessionOnly); denial != "" {
+			huma.WriteErr(api, ctx, status, denial)
+			return
+		}
+
+		next(ctx)
+	}
+}
+
+// RequireScopeHandler is the Fiber counterpart of RequireScope for the routes Huma doesn't handle
+func RequireScopeHandler(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if status, denial := requestPermissionDenial(c, scope, false); denial != "" {
+			return c.Status(status).JSON(fiber.Map{
+				"error": denial,
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// the user authenticated by AuthMiddleware
+func requestUsername(ctx context.Context) string {
+	username, _ := utils.GetFiberCtx(ctx).Locals(localsUsername).(string)
+	return username
+}
+
+// the role of the user authenticated by AuthMiddleware
+func requestRole(ctx context.Context) utils.Role {
+	role, _ := utils.GetUserRole(requestUsername(ctx))
+	return role
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role  utils.Role
+		scope string
+		want  bool
+	}{
+		{utils.RoleAdmin, ScopeSort, true},
+		{utils.RoleAdmin, ScopeMaintenance, true},
+		{utils.RoleAdmin, "", true},
+		{utils.RoleUser, ScopeDownloadCreate, true},
+		{utils.RoleUser, ScopeLibraryWrite, true},
+		{utils.RoleUser, ScopeSort, false},
+		{utils.RoleUser, ScopeMaintenance, false},
+		{utils.RoleUser, "", true},
+		{utils.RoleViewer, ScopeDownloadRead, true},
+		{utils.RoleViewer, ScopeLibraryRead, true},
+		{utils.RoleViewer, ScopeDownloadCreate, false},
+		{utils.RoleViewer, ScopeLibraryWrite, false},
+		{utils.RoleViewer, "", true},
+		// users removed from the config
+		{"", ScopeDownloadRead, false},
+		{"", "", false},
+		{"unknown", "", false},
+	}
+
+	for _, test := range tests {
+		if got := roleAllows(test.role, test.scope); got != test.want {
+			t.Errorf("roleAllows(%q, %q) = %v, want %v", test.role, test.scope, got, test.want)
+		}
+	}
+}
+
+func TestPermissionDenial(t *testing.T) {
+	previousUsers := utils.UserConfig.Users
+	t.Cleanup(func() { utils.UserConfig.Users = previousUsers })
+
+	utils.UserConfig.Users = map[string]utils.User{
+		"admin":  {Role: utils.RoleAdmin},
+		"user":   {Role: utils.RoleUser},
+		"viewer": {Role: utils.RoleViewer},
+	}
+
+	unrestricted := &models.APIToken{}
+	readOnly := &models.APIToken{Scopes: []string{ScopeDownloadRead, ScopeLibraryRead}}
+	sortOnly := &models.APIToken{Scopes: []string{ScopeSort}}
+
+	const (
+		roleDenied    = "Your role doesn't allow this operation"
+		sessionDenied = "API tokens can't be used for this operation"
+		scopeDenied   = "API token is missing the required scope"
+	)
+
+	tests := []struct {
+		name        string
+		username    string
+		token       *models.APIToken
+		scope       string
+		sessionOnly bool
+		want        string
+	}{
+		{"admin session, admin scope", "admin", nil, ScopeMaintenance, false, ""},
+		{"admin session, session only", "admin", nil, "", true, ""},
+		{"admin unrestricted token", "admin", unrestricted, ScopeSort, false, ""},
+		{"admin unrestricted token, no scope", "admin", unrestricted, "", false, ""},
+		{"admin token with the scope", "admin", sortOnly, ScopeSort, false, ""},
+		{"admin token without the scope", "admin", readOnly, ScopeSort, false, scopeDenied},
+		{"admin scoped token, no scope", "admin", readOnly, "", false, scopeDenied},
+		{"admin token, session only", "admin", unrestricted, "", true, sessionDenied},
+		{"admin token, session only with scope", "admin", sortOnly, ScopeSort, true, sessionDenied},
+
+		{"user session, user scope", "user", nil, ScopeDownloadCreate, false, ""},
+		{"user session, admin scope", "user", nil, ScopeSort, false, roleDenied},
+		{"user session, session only", "user", nil, "", true, ""},
+		{"user unrestricted token, user scope", "user", unrestricted, ScopeLibraryWrite, false, ""},
+		{"user token with an admin scope", "user", sortOnly, ScopeSort, false, roleDenied},
+		{"user token without the scope", "user", readOnly, ScopeDownloadCreate, false, scopeDenied},
+		{"user token, session only", "user", unrestricted, "", true, sessionDenied},
+
+		{"viewer session, read scope", "viewer", nil, ScopeLibraryRead, false, ""},
+		{"viewer session, write scope", "viewer", nil, ScopeLibraryWrite, false, roleDenied},
+		{"viewer token, read scope", "viewer", readOnly, ScopeDownloadRead, false, ""},
+		{"viewer unrestricted token, write scope", "viewer", unrestricted, ScopeDownloadCreate, false, roleDenied},
+		{"viewer token, session only", "viewer", readOnly, "", true, sessionDenied},
+
+		{"removed user session", "removed", nil, ScopeDownloadRead, false, roleDenied},
+		{"removed user token", "removed", unrestricted, ScopeDownloadRead, false, roleDenied},
+		{"removed user session, no scope", "removed", nil, "", false, roleDenied},
+		{"removed user session, session only", "removed", nil, "", true, roleDenied},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := permissionDenial(test.username, test.token, test.scope, test.sessionOnly); got != test.want {
+				t.Errorf("permissionDenial(%q, %v, %q, %v) = %q, want %q", test.username, test.token, test.scope, test.sessionOnly, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRequireScopeHandlerFailsClosed(t *testing.T) {
+	app := fiber.New()
+	// no AuthMiddleware in front, no user was authenticated
+	app.Get("/", RequireScopeHandler(ScopeLibraryRead), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", res.StatusCode, fiber.StatusUnauthorized)
+	}
+}
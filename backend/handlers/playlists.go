@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+// yt-dlp writes one line per downloaded file into the manifest, "NA" for fields that don't apply
+const playlistManifestTemplate = "%(playlist_id)s\t%(playlist_index)s\t%(extractor)s\t%(track_id,id)s\t%(playlist_title)s"
+
+// the manifest lives in a hidden directory of the download dir so yt-dlp can reach it from its container in development
+func playlistManifestPath(downloadID uint) string {
+	return filepath.Join(utils.UserConfig.DownloadDir, ".scyd", fmt.Sprintf("download-%d.playlist.tsv", downloadID))
+}
+
+// yt-dlp args recording the playlist position of every downloaded file
+func playlistManifestArgs(downloadID uint) []string {
+	manifestPath := playlistManifestPath(downloadID)
+
+	if err := os.MkdirAll(filepath.Dir(manifestPath), os.ModePerm); err != nil {
+		log.Printf("Failed to create %s, playlists won't be recorded: %v", filepath.Dir(manifestPath), err)
+		return []string{}
+	}
+
+	return []string{"--print-to-file", "after_move:" + playlistManifestTemplate, manifestPath}
+}
+
+// stores the playlists listed in the manifest of a download, then removes the manifest
+func importPlaylistManifest(downloadID uint) {
+	manifestPath := playlistManifestPath(downloadID)
+
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		// single tracks have no playlist
+		return
+	}
+	defer os.Remove(manifestPath)
+	defer file.Close()
+
+	type manifestPlaylist struct {
+		extractor string
+		title     string
+		entries   []models.PlaylistEntry
+	}
+
+	// key: playlist id
+	playlists := map[string]*manifestPlaylist{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 5)
+		if len(fields) != 5 || fields[0] == "NA" {
+			continue
+		}
+
+		playlistID, extractor, sourceID, title := fields[0], fields[2], fields[3], fields[4]
+
+		position, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+
+		if title == "NA" {
+			title = ""
+		}
+
+		playlist, ok := playlists[playlistID]
+		if !ok {
+			playlist = &manifestPlaylist{extractor: extractor, title: title}
+			playlists[playlistID] = playlist
+		}
+
+		playlist.entries = append(playlist.entries, models.PlaylistEntry{
+			Position:  position,
+			Extractor: extractor,
+			SourceID:  sourceID,
+		})
+	}
+
+	playlistService := services.NewPlaylistService()
+
+	for playlistID, playlist := range playlists {
+		err := playlistService.SyncPlaylist(playlist.extractor, playlistID, playlist.title, playlist.entries)
+		if err != nil {
+			log.Printf("Failed to save playlist %s: %v", playlistID, err)
+		}
+	}
+}
+
+func playlistsDir() string {
+	if utils.UserConfig.PlaylistsDir != "" {
+		return utils.UserConfig.PlaylistsDir
+	}
+	return utils.UserConfig.OutputDir
+}
+
+// (re)writes the m3u8 file of every playlist with the tracks found in the library
+// paths are relative to the playlist file, so relative to the output dir with the default playlists dir
+// returns the paths of the written files
+func writePlaylists() []string {
+	playlistService := services.NewPlaylistService()
+	libraryService := services.NewLibraryService()
+
+	playlists, err := playlistService.GetAllPlaylists()
+	if err != nil {
+		log.Printf("Failed to get playlists: %v", err)
+		return nil
+	}
+
+	dir := playlistsDir()
+	written := []string{}
+	// key: extractor
+	tracksBySource := map[string]map[string]models.Track{}
+	// file names written by this pass, lowercased for case insensitive file systems
+	usedFileNames := map[string]bool{}
+
+	for _, playlist := range playlists {
+		var content strings.Builder
+		content.WriteString("#EXTM3U\n")
+		content.WriteString("#PLAYLIST:" + playlist.Title + "\n")
+		trackCount := 0
+
+		for _, entry := range playlist.Entries {
+			tracks, ok := tracksBySource[entry.Extractor]
+			if !ok {
+				tracks, err = libraryService.GetTracksBySource(entry.Extractor)
+				if err != nil {
+					log.Printf("Failed to get tracks of %s: %v", entry.Extractor, err)
+				}
+				tracksBySource[entry.Extractor] = tracks
+			}
+
+			// not sorted (yet)
+			track, ok := tracks[entry.SourceID]
+			if !ok {
+				continue
+			}
+
+			relativePath, err := filepath.Rel(dir, track.Path)
+			if err != nil {
+				continue
+			}
+
+			displayTitle := track.Title
+			if track.Artist != nil {
+				displayTitle = track.Artist.Name + " - " + track.Title
+			}
+
+			content.WriteString("#EXTINF:-1," + displayTitle + "\n")
+			content.WriteString(filepath.ToSlash(relativePath) + "\n")
+			trackCount++
+		}
+
+		// none of its tracks are in the library anymore
+		if trackCount == 0 {
+			if playlist.FileName != "" {
+				if !usedFileNames[strings.ToLower(playlist.FileName)] {
+					os.Remove(filepath.Join(dir, playlist.FileName))
+				}
+				playlistService.SetPlaylistFileName(playlist.ID, "")
+			}
+			continue
+		}
+
+		name := sanitizePathComponent(playlist.Title)
+		if name == "" {
+			name = sanitizePathComponent(playlist.SourceID)
+		}
+		fileName := name + ".m3u8"
+
+		// playlists sharing a title, from different sites or channels, get their own file
+		if usedFileNames[strings.ToLower(fileName)] {
+			fileName = name + " [" + sanitizePathComponent(playlist.Extractor) + "] [" + sanitizePathComponent(playlist.SourceID) + "].m3u8"
+		}
+		usedFileNames[strings.ToLower(fileName)] = true
+
+		if err := writeFileAtomic(filepath.Join(dir, fileName), []byte(content.String())); err != nil {
+			log.Printf("Failed to write playlist %s: %v", fileName, err)
+			continue
+		}
+
+		// the playlist was renamed, its old file might now belong to a playlist with the same title
+		if playlist.FileName != "" && playlist.FileName != fileName && !usedFileNames[strings.ToLower(playlist.FileName)] {
+			os.Remove(filepath.Join(dir, playlist.FileName))
+		}
+
+		if playlist.FileName != fileName {
+			if err := playlistService.SetPlaylistFileName(playlist.ID, fileName); err != nil {
+				log.Printf("Failed to save playlist file name %s: %v", fileName, err)
+			}
+		}
+
+		written = append(written, filepath.Join(dir, fileName))
+	}
+
+	return written
+}
+
+// writes data next to path and renames it into place, readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
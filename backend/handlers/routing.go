@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+// what the routing rules and path templates know about a file being sorted
+// the values that need another file or process are only read when a rule asks for them
+type routingContext struct {
+	group      *sortGroup
+	provenance *SortProvenance
+
+	extractor *string
+	uploader  *string
+	duration  *float64
+}
+
+func newRoutingContext(group *sortGroup, provenance *SortProvenance) *routingContext {
+	return &routingContext{group: group, provenance: provenance}
+}
+
+// from the download file name, or from the library for files that were already sorted
+func (ctx *routingContext) Extractor() string {
+	if ctx.extractor == nil {
+		extractor := ""
+
+		if ctx.provenance != nil {
+			extractor = ctx.provenance.Extractor
+		} else if track, err := services.NewLibraryService().GetTrackByPath(ctx.group.AudioPath); err == nil {
+			extractor = track.Extractor
+		}
+
+		ctx.extractor = &extractor
+	}
+
+	return *ctx.extractor
+}
+
+// from the .info.json sidecar written by yt-dlp, the artist tag otherwise
+// yt-dlp falls back to the uploader for the artist tag of sites without artists
+func (ctx *routingContext) Uploader() string {
+	if ctx.uploader == nil {
+		uploader := infoJSONUploader(ctx.group)
+		if uploader == "" {
+			uploader = ctx.group.Metadata.Artist()
+		}
+
+		ctx.uploader = &uploader
+	}
+
+	return *ctx.uploader
+}
+
+// the uploader recorded in the .info.json sidecar of the group, empty without one
+func infoJSONUploader(group *sortGroup) string {
+	for _, sidecar := range group.Sidecars {
+		if !strings.HasSuffix(strings.ToLower(sidecar), ".info.json") {
+			continue
+		}
+
+		var info struct {
+			Uploader string `json:"uploader"`
+		}
+
+		data, err := os.ReadFile(sidecar)
+		if err == nil {
+			err = json.Unmarshal(data, &info)
+		}
+
+		if err != nil {
+			log.Printf("Failed to read uploader from %s: %v", sidecar, err)
+			return ""
+		}
+		return info.Uploader
+	}
+
+	return ""
+}
+
+// in seconds, 0 when unknown
+func (ctx *routingContext) Duration() float64 {
+	if ctx.duration == nil {
+		duration := 0.0
+
+		properties, err := utils.GetAudioProperties(ctx.group.AudioPath)
+		if err == nil {
+			duration = properties.Duration
+		} else {
+			log.Printf("Failed to read duration of %s: %v", ctx.group.AudioPath, err)
+		}
+
+		ctx.duration = &duration
+	}
+
+	return *ctx.duration
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(strings.TrimSpace(candidate), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reports whether every condition set on the rule matches the file
+func (ctx *routingContext) matches(rule utils.RoutingRuleConfig) bool {
+	if len(rule.Extractors) > 0 && !containsFold(rule.Extractors, ctx.Extractor()) {
+		return false
+	}
+
+	if len(rule.Genres) > 0 && !containsFold(rule.Genres, ctx.group.Metadata.Genre()) {
+		return false
+	}
+
+	if len(rule.Uploaders) > 0 && !containsFold(rule.Uploaders, ctx.Uploader()) {
+		return false
+	}
+
+	if !rule.TitleMatches(ctx.group.Metadata.Title()) {
+		return false
+	}
+
+	if rule.MinDurationSeconds > 0 || rule.MaxDurationSeconds > 0 {
+		duration := ctx.Duration()
+
+		// an unknown duration matches no duration condition
+		if duration == 0 ||
+			(rule.MinDurationSeconds > 0 && duration < rule.MinDurationSeconds) ||
+			(rule.MaxDurationSeconds > 0 && duration > rule.MaxDurationSeconds) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// returns the output root of the first matching routing rule with the name of the rule
+// files no rule matches go to the output dir
+func (ctx *routingContext) route() (utils.OutputRootConfig, string) {
+	for i, rule := range utils.UserConfig.Routing {
+		if !ctx.matches(rule) {
+			continue
+		}
+
+		if root, ok := utils.GetOutputRoot(rule.Output); ok {
+			return root, routingRuleName(i)
+		}
+	}
+
+	root, _ := utils.GetOutputRoot(utils.DefaultOutputRoot)
+	return root, ""
+}
+
+// rules are named after their position when they have no name
+func routingRuleName(index int) string {
+	rule := utils.UserConfig.Routing[index]
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return "rule " + strconv.Itoa(index+1)
+}
+
+var placeholderRegex = regexp.MustCompile(`\{\w+\}`)
+
+// resolves a path template like "{artist}/{album}/{file_name}" into a path relative to its root, without extension
+// every component is sanitized and empty components are dropped, so a missing album doesn't leave an empty folder
+func (ctx *routingContext) expandPathTemplate(template string, fileStem string) string {
+	metadata := ctx.group.Metadata
+
+	artist := metadata.Artist()
+	if sanitizePathComponent(artist) == "" {
+		artist = services.UnknownArtistName
+	}
+
+	albumArtist := metadata.AlbumArtist()
+	if sanitizePathComponent(albumArtist) == "" {
+		albumArtist = artist
+	}
+
+	trackNumber, _ := metadata.Track()
+	discNumber, _ := metadata.Disc()
+
+	number := func(value int, format string) string {
+		if value <= 0 {
+			return ""
+		}
+		return fmt.Sprintf(format, value)
+	}
+
+	values := map[string]func() string{
+		"artist":       func() string { return artist },
+		"album_artist": func() string { return albumArtist },
+		"album":        metadata.Album,
+		"title":        metadata.Title,
+		"genre":        metadata.Genre,
+		"year":         func() string { return number(metadata.Year(), "%d") },
+		"track":        func() string { return number(trackNumber, "%02d") },
+		"disc":         func() string { return number(discNumber, "%d") },
+		"extractor":    ctx.Extractor,
+		"uploader":     ctx.Uploader,
+		"file_name":    func() string { return fileStem },
+	}
+
+	components := []string{}
+	templateComponents := strings.Split(filepath.ToSlash(template), "/")
+
+	for i, component := range templateComponents {
+		component = placeholderRegex.ReplaceAllStringFunc(component, func(placeholder string) string {
+			if value, ok := values[strings.Trim(placeholder, "{}")]; ok {
+				return value()
+			}
+			return placeholder
+		})
+
+		component = sanitizePathComponent(component)
+
+		// the file name can't be empty
+		if component == "" && i == len(templateComponents)-1 {
+			component = fileStem
+		}
+
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+
+	return filepath.Join(components...)
+}
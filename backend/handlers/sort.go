@@ -2,51 +2,40 @@ package handlers
 
 import (
 	"context"
-	"io"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/dhowden/tag"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
 	"github.com/nicolassutter/scyd/utils"
 )
 
 type SortDownloadsResponseBody struct {
 	MovedFiles      []string `json:"moved_files"`
 	FilesWithErrors []string `json:"files_with_errors"`
+	// Leftover files moved (or to be moved, for dry runs) to the quarantine dir
+	Quarantine []SortPlanMove `json:"quarantine"`
+	DryRun     bool           `json:"dry_run"`
+	// Journal entry of the moves, can be used to undo them. 0 when nothing was moved.
+	RunID uint `json:"run_id"`
+	// Planned moves, only filled for dry runs
+	Plan         []*SortPlanEntry  `json:"plan,omitempty"`
+	SkippedFiles []SortSkippedFile `json:"skipped_files"`
 }
 type SortDownloadsResponse struct {
 	Body SortDownloadsResponseBody
 }
 
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
-
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
-	if err != nil {
-		return err
-	}
-
-	// Copy file permissions
-	sourceInfo, err := sourceFile.Stat()
-	if err != nil {
-		return err
-	}
-
-	return os.Chmod(dst, sourceInfo.Mode())
-}
-
 // removes or replaces characters that are invalid in file/directory names
 // Windows forbidden characters: < > : " / \ | ? *
 // Also removes leading/trailing spaces and dots which can cause issues
@@ -71,88 +60,860 @@ func sanitizePathComponent(name string) string {
 	return sanitized
 }
 
-// sort every audio file in the downloads dir into artist/album folders, then move them to the output dir
-func SortDownloadsDirectory() (*SortDownloadsResponse, error) {
-	files, err := os.ReadDir(utils.UserConfig.DownloadDir)
+// suffixes of files that a downloader is still writing
+var partialFileSuffixes = []string{".part", ".ytdl", ".tmp"}
 
-	if err != nil {
-		log.Printf("Failed to read download directory: %v", err)
-		return nil, huma.Error500InternalServerError("Failed to read download directory")
+func isPartialFile(name string) bool {
+	for _, suffix := range partialFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
 	}
+	return false
+}
 
-	movedFiles := []string{}
-	filesWithErrors := []string{}
+// file types that travel with their audio file, matched against what follows the audio base name
+// so "song.en.vtt" or "song.info.json" both belong to "song.mp3"
+var sidecarSuffixes = []string{
+	".lrc", ".vtt", ".srt", ".ass", // lyrics and subtitles
+	".info.json", ".description", // yt-dlp metadata
+	".jpg", ".jpeg", ".png", ".webp", // thumbnails
+}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+func isRecognizedSidecar(suffix string) bool {
+	suffix = strings.ToLower(suffix)
+
+	for _, sidecarSuffix := range sidecarSuffixes {
+		if strings.HasSuffix(suffix, sidecarSuffix) {
+			return true
 		}
+	}
+	return false
+}
+
+// values resolved from the audio metadata and used to build the destination path
+type SortTemplateValues struct {
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	FileName string `json:"file_name"`
+	// output root the file is routed to
+	Output string `json:"output"`
+	// routing rule that chose the output root, empty for files no rule matched
+	Rule string `json:"rule,omitempty"`
+}
+
+type SortPlanMove struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+type SortPlanEntry struct {
+	SortPlanMove
+	Values   SortTemplateValues `json:"values"`
+	Sidecars []SortPlanMove     `json:"sidecars"`
+	// The destination is already taken by another file, the entry will not be moved
+	Conflict bool `json:"conflict"`
+	// The destination already holds this file (hardlink or reflink modes)
+	AlreadySorted bool `json:"already_sorted"`
+	// Source of the download, parsed from the download file name
+	Provenance *SortProvenance `json:"provenance,omitempty"`
+	// Cover image written into the destination folder, either a file path or "embedded" for the artwork of the audio file
+	Cover string `json:"cover,omitempty"`
+	// The file will be looked up on MusicBrainz when sorted, its tags and destination may still change
+	PendingEnrichment bool `json:"pending_enrichment"`
+	// Audio properties of the file and of the one holding its destination, only set on conflicts of dry runs
+	// to tell a duplicate from a better or worse version of the track
+	SourceProperties   *utils.AudioProperties `json:"source_properties,omitempty"`
+	ExistingProperties *utils.AudioProperties `json:"existing_properties,omitempty"`
+
+	group *sortGroup
+}
+
+type SortSkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// an audio file and the non-audio files sharing its base name (lyrics, info json, thumbnails...)
+type sortGroup struct {
+	AudioPath string
+	Metadata  tag.Metadata
+	Sidecars  []string
+	// cover.jpg or alike found in the same directory
+	DirCover string
+}
 
-		filePath := filepath.Join(utils.UserConfig.DownloadDir, file.Name())
-		metadata, err := utils.GetMetadataFromFile(filePath)
+// walks the given directory recursively and groups every audio file with its sidecar files
+// files that are neither audio nor sidecars are returned as skipped
+func collectSortGroups(root string) ([]*sortGroup, []SortSkippedFile, error) {
+	// key: directory, value: file names in that directory
+	filesByDir := map[string][]string{}
+	dirs := []string{}
 
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			// if we fail to get metadata, skip the file as it might not be an audio file
-			log.Printf("Failed to get metadata for file %s: %v", filePath, err)
+			return err
+		}
+
+		if d.IsDir() {
+			// hidden directories hold scyd's own data, like the quarantine
+			if path != root && (strings.HasPrefix(d.Name(), ".") || path == quarantineDir()) {
+				return filepath.SkipDir
+			}
+
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		if !d.Type().IsRegular() || isPartialFile(d.Name()) {
+			return nil
+		}
+
+		dir := filepath.Dir(path)
+		filesByDir[dir] = append(filesByDir[dir], d.Name())
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := []*sortGroup{}
+	skipped := []SortSkippedFile{}
+
+	for _, dir := range dirs {
+		dirGroups := []*sortGroup{}
+		others := []SortSkippedFile{}
+		dirCover := ""
+
+		for _, name := range filesByDir[dir] {
+			filePath := filepath.Join(dir, name)
+
+			if utils.IsCoverFileName(name) {
+				dirCover = filePath
+				continue
+			}
+
+			metadata, err := utils.GetMetadataFromFile(filePath)
+
+			if err != nil {
+				// if we fail to get metadata, the file might not be an audio file
+				others = append(others, SortSkippedFile{
+					Path:   filePath,
+					Reason: "could not read metadata: " + err.Error(),
+				})
+				continue
+			}
+
+			dirGroups = append(dirGroups, &sortGroup{AudioPath: filePath, Metadata: metadata})
+		}
+
+		// attach each remaining file to the audio file with the longest matching base name
+		for _, other := range others {
+			var owner *sortGroup
+			ownerStemLen := 0
+
+			for _, group := range dirGroups {
+				stem := strings.TrimSuffix(filepath.Base(group.AudioPath), filepath.Ext(group.AudioPath))
+
+				if strings.HasPrefix(filepath.Base(other.Path), stem+".") && len(stem) > ownerStemLen {
+					owner = group
+					ownerStemLen = len(stem)
+				}
+			}
+
+			if owner == nil {
+				skipped = append(skipped, other)
+				continue
+			}
+
+			ownerStem := filepath.Base(owner.AudioPath)[:ownerStemLen]
+			if !isRecognizedSidecar(strings.TrimPrefix(filepath.Base(other.Path), ownerStem)) {
+				skipped = append(skipped, SortSkippedFile{
+					Path:   other.Path,
+					Reason: "unrecognised sidecar type",
+				})
+				continue
+			}
+
+			owner.Sidecars = append(owner.Sidecars, other.Path)
+		}
+
+		for _, group := range dirGroups {
+			group.DirCover = dirCover
+		}
+
+		if dirCover != "" && len(dirGroups) == 0 {
+			skipped = append(skipped, SortSkippedFile{
+				Path:   dirCover,
+				Reason: "no audio file in the same directory",
+			})
+		}
+
+		groups = append(groups, dirGroups...)
+	}
+
+	return groups, skipped, nil
+}
+
+// removes every empty directory below root, deepest first. root itself is kept.
+// returns the removed directories
+func pruneEmptyDirs(root string) []string {
+	removed := []string{}
+	dirs := []string{}
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == root {
+			return nil
+		}
+
+		// scyd's own directories, like the quarantine
+		if strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		dirs = append(dirs, path)
+		return nil
+	})
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+
+		if err := os.Remove(dirs[i]); err != nil {
+			log.Printf("Failed to remove empty directory %s: %v", dirs[i], err)
 			continue
 		}
+		removed = append(removed, dirs[i])
+	}
+
+	return removed
+}
+
+// resolves the destination of every group without touching the filesystem
+func buildSortPlan(groups []*sortGroup) []*SortPlanEntry {
+	plan := []*SortPlanEntry{}
+	// destinations claimed by earlier entries of the plan
+	claimed := map[string]bool{}
+	// folders that will get a cover from an earlier entry of the plan
+	coveredDirs := map[string]bool{}
+
+	// a destination conflicts when another file already sits there or an earlier entry targets it
+	isTaken := func(destination string) bool {
+		if claimed[destination] {
+			return true
+		}
+		_, err := os.Lstat(destination)
+		return err == nil
+	}
+
+	for _, group := range groups {
+		metadata := group.Metadata
 
 		artist := metadata.Artist()
 		if artist == "" {
-			artist = "Unknown Artist"
+			artist = services.UnknownArtistName
 		}
 
 		artist = sanitizePathComponent(artist)
 
 		if artist == "" {
-			artist = "Unknown Artist"
+			artist = services.UnknownArtistName
 		}
 
 		album := sanitizePathComponent(metadata.Album())
 
-		// If album is empty, filepath.Join will skip it, creating: /output/Artist/file.mp3
-		// If album exists, it creates: /output/Artist/Album/file.mp3
-		newDir := filepath.Join(utils.UserConfig.OutputDir, artist, album)
+		_, provenance := parseDownloadFileName(strings.TrimSuffix(filepath.Base(group.AudioPath), filepath.Ext(group.AudioPath)))
+
+		// the default template creates /output/Artist/Album/file.mp3, or /output/Artist/file.mp3 without album
+		routing := newRoutingContext(group, provenance)
+		root, rule := routing.route()
+		relativePath := routing.expandPathTemplate(root.PathTemplate, planFileStem(group))
+
+		fileName := filepath.Base(relativePath) + filepath.Ext(group.AudioPath)
+		newDir := filepath.Join(root.Dir, filepath.Dir(relativePath))
+		sidecarNames := planSidecarNames(group, filepath.Base(relativePath))
+
+		entry := &SortPlanEntry{
+			SortPlanMove: SortPlanMove{
+				Source:      group.AudioPath,
+				Destination: filepath.Join(newDir, fileName),
+			},
+			Values: SortTemplateValues{
+				Artist:   artist,
+				Album:    album,
+				FileName: fileName,
+				Output:   root.Name,
+				Rule:     rule,
+			},
+			Sidecars:   []SortPlanMove{},
+			Provenance: provenance,
+			group:      group,
+		}
+
+		for _, sidecar := range group.Sidecars {
+			entry.Sidecars = append(entry.Sidecars, SortPlanMove{
+				Source:      sidecar,
+				Destination: filepath.Join(newDir, sidecarNames[sidecar]),
+			})
+		}
+
+		if utils.IsSameFile(entry.Source, entry.Destination) {
+			// hardlink and reflink modes leave the source in place, don't sort it twice
+			// in move mode the source is a leftover of a copy, applying the move removes it
+			entry.AlreadySorted = utils.UserConfig.MoveMode.KeepsSource()
+		} else if isTaken(entry.Destination) {
+			entry.Conflict = true
+		}
+
+		if !entry.Conflict {
+			claimed[entry.Destination] = true
+			for _, sidecar := range entry.Sidecars {
+				claimed[sidecar.Destination] = true
+			}
+
+			if utils.UserConfig.Covers.Enabled && !coveredDirs[newDir] && utils.FindCoverFile(newDir) == "" {
+				// the root of the downloads dir mixes unrelated downloads, there the embedded artwork is more reliable
+				preferDirCover := filepath.Dir(group.AudioPath) != utils.UserConfig.DownloadDir
+
+				if group.DirCover != "" && (preferDirCover || metadata.Picture() == nil) {
+					entry.Cover = group.DirCover
+				} else if metadata.Picture() != nil {
+					entry.Cover = "embedded"
+				}
+
+				coveredDirs[newDir] = entry.Cover != ""
+			}
+		}
+
+		plan = append(plan, entry)
+	}
+
+	return plan
+}
+
+// reads the audio properties of both files of each conflict, the destination is either
+// a file of the library or the source of an earlier entry of the plan
+func describeConflicts(plan []*SortPlanEntry) {
+	for i, entry := range plan {
+		if !entry.Conflict {
+			continue
+		}
+
+		existing := entry.Destination
+		if _, err := os.Lstat(existing); err != nil {
+			for _, earlier := range plan[:i] {
+				if !earlier.Conflict && earlier.Destination == entry.Destination {
+					existing = earlier.Source
+					break
+				}
+			}
+		}
+
+		entry.SourceProperties = readConflictProperties(entry.Source)
+		entry.ExistingProperties = readConflictProperties(existing)
+	}
+}
+
+// nil when the properties can't be read, ffprobe is optional
+func readConflictProperties(path string) *utils.AudioProperties {
+	properties, err := utils.GetAudioProperties(path)
+	if err != nil {
+		if !errors.Is(err, utils.ErrFfprobeNotFound) {
+			log.Printf("Failed to read audio properties of %s: %v", path, err)
+		}
+		return nil
+	}
+
+	return properties
+}
+
+// records the moves of a sort run, the run is only created once something is moved
+type sortJournal struct {
+	service *services.SortJournalService
+	run     *models.SortRun
+}
+
+func newSortJournal() *sortJournal {
+	return &sortJournal{service: services.NewSortJournalService()}
+}
+
+func (j *sortJournal) ensureRun() bool {
+	if j.run != nil {
+		return true
+	}
+
+	run, err := j.service.CreateRun()
+	if err != nil {
+		log.Printf("Failed to create sort run: %v", err)
+		return false
+	}
+
+	j.run = run
+	return true
+}
+
+func (j *sortJournal) recordDirs(dirs []string) {
+	if len(dirs) == 0 || !j.ensureRun() {
+		return
+	}
+
+	if err := j.service.RecordCreatedDirs(j.run.ID, dirs); err != nil {
+		log.Printf("Failed to record created directories for sort run %d: %v", j.run.ID, err)
+	}
+}
+
+func (j *sortJournal) recordMove(move SortPlanMove) {
+	j.recordMoveWithMode(move, utils.UserConfig.MoveMode)
+}
+
+func (j *sortJournal) recordMoveWithMode(move SortPlanMove, mode utils.MoveMode) {
+	if !j.ensureRun() {
+		return
+	}
+
+	sortMove := &models.SortMove{
+		RunID:       j.run.ID,
+		Source:      move.Source,
+		Destination: move.Destination,
+		Mode:        string(mode),
+	}
+
+	if move.Source == "" {
+		sortMove.Mode = models.SortMoveModeCreated
+	}
+
+	if info, err := os.Stat(move.Destination); err == nil {
+		sortMove.Size = info.Size()
+		sortMove.ModTime = info.ModTime()
+	}
+
+	if err := j.service.RecordMove(sortMove); err != nil {
+		log.Printf("Failed to record move of %s in sort run %d: %v", move.Source, j.run.ID, err)
+	}
+}
+
+// records a file the run wrote from scratch, undoing the run deletes it
+func (j *sortJournal) recordCreated(path string) {
+	j.recordMove(SortPlanMove{Destination: path})
+}
+
+// where a cover removed from the downloads dir by a sort run is kept
+func sortRunCoverPath(runID uint, cover string) string {
+	rel, err := filepath.Rel(utils.UserConfig.DownloadDir, cover)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(cover)
+	}
+	return filepath.Join(sortRunCoversDir(), fmt.Sprint(runID), rel)
+}
+
+func sortRunCoversDir() string {
+	return filepath.Join(utils.UserConfig.DownloadDir, ".scyd", "sort-runs")
+}
+
+func (j *sortJournal) runID() uint {
+	if j.run == nil {
+		return 0
+	}
+	return j.run.ID
+}
+
+// moves a single planned file, returns false when the destination already holds it
+func applySortMove(move SortPlanMove) (bool, error) {
+	if utils.IsSameFile(move.Source, move.Destination) {
+		// an earlier copy succeeded but removing its source failed, don't leave a duplicate behind
+		if !utils.UserConfig.MoveMode.KeepsSource() {
+			return false, os.Remove(move.Source)
+		}
+		return false, nil
+	}
+
+	// never overwrite a file that appeared after planning
+	if _, err := os.Lstat(move.Destination); err == nil {
+		return false, fs.ErrExist
+	}
+
+	return true, utils.MoveFile(move.Source, move.Destination, utils.UserConfig.MoveMode)
+}
+
+// removes the empty directories between dir and root, root itself is kept
+func pruneEmptyParents(dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+
+		if err := os.Remove(dir); err != nil {
+			log.Printf("Failed to remove empty directory %s: %v", dir, err)
+			return
+		}
+
+		dir = filepath.Dir(dir)
+	}
+}
+
+// returns the recognised sidecar files next to an audio file
+func findSidecars(path string) ([]string, error) {
+	sidecars := []string{}
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || entry.Name() == filepath.Base(path) {
+			continue
+		}
+
+		if suffix, found := strings.CutPrefix(entry.Name(), stem); found && strings.HasPrefix(suffix, ".") && isRecognizedSidecar(suffix) {
+			sidecars = append(sidecars, filepath.Join(filepath.Dir(path), entry.Name()))
+		}
+	}
+
+	return sidecars, nil
+}
+
+// moves a file of the library (and its sidecars) to the path its current tags resolve to
+// returns the new path of the file, which is unchanged when the file is already in place
+func resortLibraryFile(path string) (string, error) {
+	metadata, err := utils.GetMetadataFromFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sidecars, err := findSidecars(path)
+	if err != nil {
+		return "", err
+	}
+
+	group := &sortGroup{
+		AudioPath: path,
+		Metadata:  metadata,
+		Sidecars:  sidecars,
+		DirCover:  utils.FindCoverFile(filepath.Dir(path)),
+	}
+
+	entry := buildSortPlan([]*sortGroup{group})[0]
+
+	if entry.Destination == path {
+		return path, nil
+	}
+
+	if entry.Conflict {
+		return "", fmt.Errorf("%s already exists", entry.Destination)
+	}
+
+	createdDirs, err := utils.MkdirAllTracked(filepath.Dir(entry.Destination))
+	if err != nil {
+		return "", err
+	}
+
+	journal := newSortJournal()
+	journal.recordDirs(createdDirs)
+
+	moved, err := applySortMove(entry.SortPlanMove)
+	if err != nil {
+		return "", err
+	}
+	if moved {
+		journal.recordMove(entry.SortPlanMove)
+	}
+	applyEntryCover(entry, journal)
+
+	for _, sidecar := range entry.Sidecars {
+		moved, err := applySortMove(sidecar)
+
+		if err != nil {
+			log.Printf("Failed to move sidecar file %s to %s: %v", sidecar.Source, sidecar.Destination, err)
+			continue
+		}
+
+		if moved {
+			journal.recordMove(sidecar)
+		}
+	}
+
+	if root, ok := utils.OutputRootOf(path); ok {
+		pruneEmptyParents(filepath.Dir(path), root.Dir)
+	}
+
+	return entry.Destination, nil
+}
+
+// directory receiving the leftovers of the downloads dir
+func quarantineDir() string {
+	if utils.UserConfig.Quarantine.Dir != "" {
+		return filepath.Clean(utils.UserConfig.Quarantine.Dir)
+	}
+	return filepath.Join(utils.UserConfig.DownloadDir, ".quarantine")
+}
+
+// plans the move of leftover files to the quarantine dir, keeping their path relative to the downloads dir
+// recently modified files are left alone, a download might still be writing them
+func planQuarantine(skippedFiles []SortSkippedFile) []SortPlanMove {
+	moves := []SortPlanMove{}
+
+	if !utils.UserConfig.Quarantine.Enabled {
+		return moves
+	}
+
+	minAge := time.Duration(utils.UserConfig.Quarantine.MinAgeMinutes) * time.Minute
+
+	for _, skipped := range skippedFiles {
+		info, err := os.Stat(skipped.Path)
+		if err != nil || time.Since(info.ModTime()) < minAge {
+			continue
+		}
+
+		relativePath, err := filepath.Rel(utils.UserConfig.DownloadDir, skipped.Path)
+		if err != nil {
+			continue
+		}
+
+		moves = append(moves, SortPlanMove{
+			Source:      skipped.Path,
+			Destination: filepath.Join(quarantineDir(), relativePath),
+		})
+	}
+
+	return moves
+}
+
+// only one sort can move files at a time, downloads, the watcher and the API can all start one
+var sortMutex sync.Mutex
+
+// reports whether path is one of roots or inside one of them
+func isWithinAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sort every audio file in the downloads dir (and its subdirectories) into artist/album folders,
+// then move them and their sidecar files to the output dir
+// with dryRun, only the plan is returned and nothing is moved
+func SortDownloadsDirectory(dryRun bool) (*SortDownloadsResponse, error) {
+	return sortDownloads(dryRun, nil, nil)
+}
+
+// same as SortDownloadsDirectory, limited to the files at or under the given paths of the downloads dir
+// the files skip reports true for are left in place, skip can be nil
+func SortDownloadPaths(paths []string, skip func(path string) bool) (*SortDownloadsResponse, error) {
+	return sortDownloads(false, paths, skip)
+}
+
+func sortDownloads(dryRun bool, only []string, skip func(path string) bool) (*SortDownloadsResponse, error) {
+	sortMutex.Lock()
+	defer sortMutex.Unlock()
+
+	groups, skippedFiles, err := collectSortGroups(utils.UserConfig.DownloadDir)
+
+	if err != nil {
+		log.Printf("Failed to read download directory: %v", err)
+		return nil, huma.Error500InternalServerError("Failed to read download directory")
+	}
+
+	// a running download can still convert or rewrite its files, they are sorted once it completes
+	isSkipped := func(path string) bool {
+		return downloadManager.IsDownloadOutput(path) || (skip != nil && skip(path))
+	}
+	groups = slices.DeleteFunc(groups, func(group *sortGroup) bool {
+		return isSkipped(group.AudioPath)
+	})
+	skippedFiles = slices.DeleteFunc(skippedFiles, func(skipped SortSkippedFile) bool {
+		return isSkipped(skipped.Path)
+	})
+
+	if only != nil {
+		groups = slices.DeleteFunc(groups, func(group *sortGroup) bool {
+			return !isWithinAny(group.AudioPath, only)
+		})
+		skippedFiles = slices.DeleteFunc(skippedFiles, func(skipped SortSkippedFile) bool {
+			return !isWithinAny(skipped.Path, only)
+		})
+	}
+
+	plan := buildSortPlan(groups)
+	quarantine := planQuarantine(skippedFiles)
+
+	if dryRun {
+		markPendingEnrichment(plan)
+		describeConflicts(plan)
+
+		return &SortDownloadsResponse{
+			Body: SortDownloadsResponseBody{
+				MovedFiles:      []string{},
+				FilesWithErrors: []string{},
+				Quarantine:      quarantine,
+				DryRun:          true,
+				Plan:            plan,
+				SkippedFiles:    skippedFiles,
+			},
+		}, nil
+	}
+
+	// canonical tags change where files are sorted to
+	if enrichSortPlan(plan) {
+		plan = buildSortPlan(groups)
+	}
+
+	movedFiles := []string{}
+	filesWithErrors := []string{}
+	journal := newSortJournal()
+	// covers of download directories, removed once their audio files are sorted
+	usedDirCovers := map[string]bool{}
+
+	for _, entry := range plan {
+		if entry.AlreadySorted {
+			continue
+		}
+
+		if entry.Conflict {
+			log.Printf("Not moving %s, %s already exists", entry.Source, entry.Destination)
+			filesWithErrors = append(filesWithErrors, entry.Source)
+			continue
+		}
 
-		err = os.MkdirAll(newDir, os.ModePerm)
+		newDir := filepath.Dir(entry.Destination)
+		createdDirs, err := utils.MkdirAllTracked(newDir)
 
 		if err != nil {
 			log.Printf("Failed to create directory %s: %v", newDir, err)
-			filesWithErrors = append(filesWithErrors, filePath)
+			filesWithErrors = append(filesWithErrors, entry.Source)
+			continue
+		}
+
+		journal.recordDirs(createdDirs)
+		embedMissingCover(entry)
+
+		moved, err := applySortMove(entry.SortPlanMove)
+
+		if err != nil {
+			log.Printf("Failed to move file %s to %s: %v", entry.Source, entry.Destination, err)
+			filesWithErrors = append(filesWithErrors, entry.Source)
 			continue
 		}
 
-		newFilePath := filepath.Join(newDir, file.Name())
+		// a removed leftover copy has nothing to undo
+		if moved {
+			journal.recordMove(entry.SortPlanMove)
+		}
+		movedFiles = append(movedFiles, entry.Destination)
+
+		if err := services.NewTagMatchService().UpdatePath(entry.Source, entry.Destination); err != nil {
+			log.Printf("Failed to update MusicBrainz match of %s: %v", entry.Source, err)
+		}
+
+		// sidecars only follow once their audio file is in place
+		for _, sidecar := range entry.Sidecars {
+			moved, err := applySortMove(sidecar)
+
+			if err != nil {
+				log.Printf("Failed to move sidecar file %s to %s: %v", sidecar.Source, sidecar.Destination, err)
+				filesWithErrors = append(filesWithErrors, sidecar.Source)
+				continue
+			}
+
+			if moved {
+				journal.recordMove(sidecar)
+				movedFiles = append(movedFiles, sidecar.Destination)
+			}
+		}
+
+		applyEntryCover(entry, journal)
+
+		if entry.group.DirCover != "" {
+			usedDirCovers[entry.group.DirCover] = true
+		}
+	}
+
+	quarantined := []SortPlanMove{}
 
-		// copy the file to the new location
-		err = copyFile(filePath, newFilePath)
+	for _, move := range quarantine {
+		createdDirs, err := utils.MkdirAllTracked(filepath.Dir(move.Destination))
+
+		if err == nil {
+			journal.recordDirs(createdDirs)
+			// quarantined files always leave the downloads dir, whatever the move mode
+			if _, err = os.Lstat(move.Destination); err == nil {
+				err = fs.ErrExist
+			} else {
+				err = utils.MoveFile(move.Source, move.Destination, utils.MoveModeMove)
+			}
+		}
 
 		if err != nil {
-			log.Printf("Failed to copy file %s to %s: %v", filePath, newFilePath, err)
-			filesWithErrors = append(filesWithErrors, filePath)
+			log.Printf("Failed to quarantine %s: %v", move.Source, err)
+			filesWithErrors = append(filesWithErrors, move.Source)
 			continue
 		}
 
-		// remove the original file
-		err = os.Remove(filePath)
+		journal.recordMoveWithMode(move, utils.MoveModeMove)
+		quarantined = append(quarantined, move)
+	}
+
+	// the covers now live in the album folders, link modes keep every source file
+	// they are set aside with the run instead of deleted so undoing it can put them back
+	if !utils.UserConfig.MoveMode.KeepsSource() && len(usedDirCovers) > 0 && journal.ensureRun() {
+		for dirCover := range usedDirCovers {
+			move := SortPlanMove{Source: dirCover, Destination: sortRunCoverPath(journal.runID(), dirCover)}
+
+			err := os.MkdirAll(filepath.Dir(move.Destination), os.ModePerm)
+			if err == nil {
+				err = utils.MoveFile(move.Source, move.Destination, utils.MoveModeMove)
+			}
+			if err != nil {
+				log.Printf("Failed to remove cover %s: %v", dirCover, err)
+				continue
+			}
+
+			journal.recordMoveWithMode(move, utils.MoveModeMove)
+		}
+	}
+
+	pruneEmptyDirs(utils.UserConfig.DownloadDir)
+	indexLibraryFiles(movedFiles)
 
+	// the download file name is gone, keep where the file came from in the library
+	for _, entry := range plan {
+		if entry.Provenance == nil || !slices.Contains(movedFiles, entry.Destination) {
+			continue
+		}
+
+		err := services.NewLibraryService().SetTrackProvenance(entry.Destination, entry.Provenance.Extractor, entry.Provenance.ID)
 		if err != nil {
-			log.Printf("Failed to remove original file %s: %v", filePath, err)
-			// Note: file was copied successfully, so we still count it as moved
-			// but we could optionally clean up the copy if removal fails
+			log.Printf("Failed to save provenance of %s: %v", entry.Destination, err)
 		}
+	}
 
-		movedFiles = append(movedFiles, newFilePath)
+	// sorted tracks can now be found by where they were downloaded from
+	if len(movedFiles) > 0 {
+		refreshMediaServers(slices.Concat(movedFiles, writePlaylists()))
 	}
 
 	return &SortDownloadsResponse{
 		Body: SortDownloadsResponseBody{
 			MovedFiles:      movedFiles,
 			FilesWithErrors: filesWithErrors,
+			Quarantine:      quarantined,
+			RunID:           journal.runID(),
+			SkippedFiles:    skippedFiles,
 		},
 	}, nil
 }
 
-func SortDownloadsHandler(c context.Context, input *struct{}) (*SortDownloadsResponse, error) {
-	return SortDownloadsDirectory()
+type SortDownloadsRequest struct {
+	DryRun bool `query:"dry_run" doc:"Only return the planned moves without touching the filesystem"`
+}
+
+func SortDownloadsHandler(c context.Context, input *SortDownloadsRequest) (*SortDownloadsResponse, error) {
+	return SortDownloadsDirectory(input.DryRun)
 }
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+type GetSortRunsResponse struct {
+	Body GetSortRunsResponseBody
+}
+
+type GetSortRunsResponseBody struct {
+	Runs []models.SortRun `json:"runs"`
+}
+
+type GetSortRunResponse struct {
+	Body *models.SortRun
+}
+
+type UndoSortRunResponse struct {
+	Body UndoSortRunResponseBody
+}
+
+type UndoSortRunResponseBody struct {
+	RestoredFiles   []string `json:"restored_files"`
+	FilesWithErrors []string `json:"files_with_errors"`
+}
+
+// returns why a journaled move can no longer be undone safely, or an empty string
+func sortMoveUndoConflict(move models.SortMove) string {
+	info, err := os.Stat(move.Destination)
+	if err != nil {
+		return "file no longer exists"
+	}
+
+	if info.Size() != move.Size || !info.ModTime().Equal(move.ModTime) {
+		return "file was modified after sorting"
+	}
+
+	// link modes leave the original in place and created files have no original, there is nothing to move back
+	if move.Mode != string(utils.MoveModeMove) && move.Mode != "" {
+		return ""
+	}
+
+	if _, err := os.Lstat(move.Source); err == nil {
+		return "original path is already taken"
+	}
+
+	return ""
+}
+
+// download covers set aside by the run are purged by the maintenance, undoing the run goes on without them
+func isPurgedSortRunCover(move models.SortMove) bool {
+	if !strings.HasPrefix(move.Destination, sortRunCoversDir()+string(filepath.Separator)) {
+		return false
+	}
+
+	_, err := os.Lstat(move.Destination)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+func getSortRun(id uint) (*models.SortRun, error) {
+	run, err := services.NewSortJournalService().GetRun(id)
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, huma.Error404NotFound("Sort run not found")
+	}
+
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get sort run: " + err.Error())
+	}
+
+	return run, nil
+}
+
+// GetSortRunsHandler lists every sort run, most recent first
+func GetSortRunsHandler(ctx context.Context, input *struct{}) (*GetSortRunsResponse, error) {
+	runs, err := services.NewSortJournalService().GetAllRuns()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get sort runs: " + err.Error())
+	}
+
+	return &GetSortRunsResponse{
+		Body: GetSortRunsResponseBody{
+			Runs: runs,
+		},
+	}, nil
+}
+
+// GetSortRunHandler returns a sort run with its moves
+func GetSortRunHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*GetSortRunResponse, error) {
+	run, err := getSortRun(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetSortRunResponse{Body: run}, nil
+}
+
+// UndoSortRunHandler moves the files of a sort run back to where they were found
+// The undo is refused as a whole when any file changed since the run
+func UndoSortRunHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*UndoSortRunResponse, error) {
+	journalService := services.NewSortJournalService()
+
+	run, err := getSortRun(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if run.State == models.SortRunStateUndone {
+		return nil, huma.Error409Conflict("Sort run was already undone")
+	}
+
+	conflicts := []error{}
+	for _, move := range run.Moves {
+		if move.UndoneAt != nil || isPurgedSortRunCover(move) {
+			continue
+		}
+
+		if reason := sortMoveUndoConflict(move); reason != "" {
+			conflicts = append(conflicts, &huma.ErrorDetail{
+				Message:  reason,
+				Location: move.Destination,
+				Value:    move.Source,
+			})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, huma.Error409Conflict("Some files changed since the sort run, nothing was undone", conflicts...)
+	}
+
+	restoredFiles := []string{}
+	filesWithErrors := []string{}
+	// library files that were moved back or unlinked
+	removedFiles := []string{}
+
+	// undo in reverse order so sidecars are handled before their audio file
+	for i := len(run.Moves) - 1; i >= 0; i-- {
+		move := run.Moves[i]
+
+		if move.UndoneAt != nil || isPurgedSortRunCover(move) {
+			continue
+		}
+
+		if move.Mode == string(utils.MoveModeMove) || move.Mode == "" {
+			err = os.MkdirAll(filepath.Dir(move.Source), os.ModePerm)
+			if err == nil {
+				err = utils.MoveFile(move.Destination, move.Source, utils.MoveModeMove)
+			}
+		} else {
+			err = os.Remove(move.Destination)
+		}
+
+		if err != nil {
+			log.Printf("Failed to undo move of %s: %v", move.Destination, err)
+			filesWithErrors = append(filesWithErrors, move.Destination)
+			continue
+		}
+
+		if err := journalService.MarkMoveUndone(move.ID); err != nil {
+			log.Printf("Failed to mark move %d as undone: %v", move.ID, err)
+		}
+
+		if err := services.NewTagMatchService().UpdatePath(move.Destination, move.Source); err != nil {
+			log.Printf("Failed to update MusicBrainz match of %s: %v", move.Destination, err)
+		}
+
+		if move.Mode != models.SortMoveModeCreated {
+			restoredFiles = append(restoredFiles, move.Source)
+		}
+		removedFiles = append(removedFiles, move.Destination)
+	}
+
+	// files dropped into the downloads dir by hand would be sorted again right away
+	ignoreRestoredFiles(restoredFiles)
+
+	indexLibraryFiles(removedFiles)
+
+	if len(removedFiles) > 0 {
+		refreshMediaServers(slices.Concat(removedFiles, writePlaylists()))
+	}
+
+	// remove the directories the run created, deepest first, as long as they are empty
+	for i := len(run.CreatedDirs) - 1; i >= 0; i-- {
+		dir := run.CreatedDirs[i].Path
+		entries, err := os.ReadDir(dir)
+
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+
+		if err := os.Remove(dir); err != nil {
+			log.Printf("Failed to remove directory %s: %v", dir, err)
+		}
+	}
+
+	if len(filesWithErrors) == 0 {
+		if err := journalService.UpdateRunState(run.ID, models.SortRunStateUndone); err != nil {
+			log.Printf("Failed to update state of sort run %d: %v", run.ID, err)
+		}
+	}
+
+	return &UndoSortRunResponse{
+		Body: UndoSortRunResponseBody{
+			RestoredFiles:   restoredFiles,
+			FilesWithErrors: filesWithErrors,
+		},
+	}, nil
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+type DirectoryStorage struct {
+	Name string `json:"name" enum:"downloads,output,transcode_cache"`
+	// name of the output root, only for output directories
+	OutputRoot string `json:"output_root,omitempty"`
+	Path       string `json:"path"`
+	// total size of the files in the directory, in bytes
+	Size int64 `json:"size"`
+	// filesystem of the directory, in bytes, omitted on platforms that can't report it
+	Total   uint64 `json:"total,omitempty"`
+	Free    uint64 `json:"free,omitempty"`
+	Used    uint64 `json:"used,omitempty"`
+	MinFree uint64 `json:"min_free"`
+	// less than MinFree is available, downloads or copies are refused
+	LowSpace bool `json:"low_space"`
+}
+
+type StorageResponse struct {
+	Body StorageResponseBody
+}
+
+type StorageResponseBody struct {
+	Directories []DirectoryStorage       `json:"directories"`
+	Artists     []services.ArtistStorage `json:"artists"`
+}
+
+// total size of the regular files below dir
+func directorySize(dir string) (int64, error) {
+	size := int64(0)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// files can disappear while walking, a download finished or a sort moved them
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
+
+func getDirectoryStorage(name string, dir string) (DirectoryStorage, error) {
+	storage := DirectoryStorage{Name: name, Path: dir, MinFree: utils.MinFreeSpace(dir)}
+
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return storage, nil
+	}
+
+	size, err := directorySize(dir)
+	if err != nil {
+		return storage, err
+	}
+	storage.Size = size
+
+	usage, err := utils.GetDiskUsage(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return storage, nil
+	}
+	if err != nil {
+		return storage, err
+	}
+
+	storage.Total = usage.Total
+	storage.Free = usage.Free
+	storage.Used = usage.Used
+	storage.LowSpace = usage.Free < storage.MinFree
+
+	return storage, nil
+}
+
+// GetStorageHandler reports the disk usage of scyd's directories and of each artist of the library
+func GetStorageHandler(ctx context.Context, input *struct{}) (*StorageResponse, error) {
+	type storageDir struct{ name, outputRoot, path string }
+
+	dirs := []storageDir{{"downloads", "", utils.UserConfig.DownloadDir}}
+	for _, root := range utils.OutputRoots() {
+		dirs = append(dirs, storageDir{"output", root.Name, root.Dir})
+	}
+	dirs = append(dirs, storageDir{"transcode_cache", "", utils.UserConfig.Transcoding.CacheDir})
+
+	res := &StorageResponse{
+		Body: StorageResponseBody{
+			Directories: []DirectoryStorage{},
+		},
+	}
+
+	for _, dir := range dirs {
+		storage, err := getDirectoryStorage(dir.name, dir.path)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to read " + dir.path + ": " + err.Error())
+		}
+		storage.OutputRoot = dir.outputRoot
+		res.Body.Directories = append(res.Body.Directories, storage)
+	}
+
+	artists, err := services.NewLibraryService().GetArtistStorage()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get artist storage: " + err.Error())
+	}
+	res.Body.Artists = artists
+
+	return res, nil
+}
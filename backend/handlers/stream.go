@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolassutter/scyd/services"
+	"gorm.io/gorm"
+)
+
+// browsers don't agree on these, mime.TypeByExtension also depends on the system mime database
+var audioContentTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".webm": "audio/webm",
+}
+
+func audioContentType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	if contentType, ok := audioContentTypes[ext]; ok {
+		return contentType
+	}
+
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
+
+// parses a single range "bytes=start-end" header value against a file of the given size
+// multiple ranges are not supported, ok is false for unsatisfiable ranges
+func parseByteRange(header string, size int64) (start int64, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return 0, 0, false
+	}
+
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	// suffix range: the last N bytes
+	if startStr == "" {
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false
+		}
+
+		if suffix > size {
+			suffix = size
+		}
+
+		return size - suffix, suffix, size > 0
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, true
+}
+
+// closes the file once fasthttp is done streaming the section
+type sectionReadCloser struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (s *sectionReadCloser) Close() error {
+	return s.file.Close()
+}
+
+// sends a file with ETag, Last-Modified and single byte range support
+func sendFileWithRanges(c *fiber.Ctx, path string, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "File not found")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read file")
+	}
+
+	size := info.Size()
+	etag := fmt.Sprintf(`"%x-%x"`, size, info.ModTime().UnixNano())
+
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, info.ModTime().UTC().Format(http.TimeFormat))
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		file.Close()
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	start, length := int64(0), size
+	rangeHeader := c.Get(fiber.HeaderRange)
+	ifRange := c.Get(fiber.HeaderIfRange)
+
+	// ranges of an outdated representation are ignored, the whole file is sent instead
+	// multiple ranges are not supported either, which the spec allows to answer with the whole file
+	if rangeHeader != "" && (ifRange == "" || ifRange == etag) && !strings.Contains(rangeHeader, ",") {
+		var ok bool
+		start, length, ok = parseByteRange(rangeHeader, size)
+
+		if !ok {
+			file.Close()
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+		}
+
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		c.Status(fiber.StatusPartialContent)
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Context().SetBodyStream(&sectionReadCloser{
+		SectionReader: io.NewSectionReader(file, start, length),
+		file:          file,
+	}, int(length))
+
+	return nil
+}
+
+// StreamTrackHandler serves the audio file of a library track
+// with ?format=opus&bitrate=128, the track is transcoded (and cached) first
+// This is a plain Fiber handler because Huma responses don't support byte ranges
+func StreamTrackHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid track ID")
+	}
+
+	track, err := services.NewLibraryService().GetTrack(uint(id))
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "Track not found")
+	}
+
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to get track")
+	}
+
+	if c.Query("format") != "" {
+		return sendTranscodedTrack(c, track)
+	}
+
+	return sendFileWithRanges(c, track.Path, audioContentType(track.Path))
+}
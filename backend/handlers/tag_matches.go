@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+type TagMatchesResponse struct {
+	Body TagMatchesResponseBody
+}
+
+type TagMatchesResponseBody struct {
+	Matches []models.TagMatch `json:"matches"`
+}
+
+// GetTagMatchesHandler lists the MusicBrainz matches, by default the ones waiting for a review
+func GetTagMatchesHandler(ctx context.Context, input *struct {
+	State string `query:"state" default:"pending" enum:"pending,applied,rejected,no_match"`
+}) (*TagMatchesResponse, error) {
+	matches, err := services.NewTagMatchService().GetMatches(models.TagMatchState(input.State))
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get tag matches: " + err.Error())
+	}
+
+	return &TagMatchesResponse{
+		Body: TagMatchesResponseBody{
+			Matches: matches,
+		},
+	}, nil
+}
+
+type TagMatchResponse struct {
+	Body models.TagMatch
+}
+
+func getReviewableTagMatch(id uint) (*models.TagMatch, error) {
+	match, err := services.NewTagMatchService().GetMatch(id)
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, huma.Error404NotFound("Tag match not found")
+	}
+
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get tag match: " + err.Error())
+	}
+
+	if match.State != models.TagMatchStatePending && match.State != models.TagMatchStateRejected {
+		return nil, huma.Error409Conflict("Only pending or rejected matches can be reviewed")
+	}
+
+	return match, nil
+}
+
+// ApplyTagMatchHandler writes the tags of a reviewed match, library files are sorted again to match them
+func ApplyTagMatchHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*TagMatchResponse, error) {
+	match, err := getReviewableTagMatch(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	update := tagMatchUpdate(match)
+	path := match.Path
+
+	if track, err := services.NewLibraryService().GetTrackByPath(match.Path); err == nil {
+		update.Sort = true
+		// also follows the file in the matches when it moves
+		path, err = updateTrackTags(track, update)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// not sorted yet, the next sort uses the new tags
+		if _, err := os.Stat(match.Path); err != nil {
+			return nil, huma.Error404NotFound("The file of this match no longer exists")
+		}
+
+		if err := utils.WriteTags(match.Path, update.tagUpdate()); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to write tags: " + err.Error())
+		}
+	}
+
+	tagMatchService := services.NewTagMatchService()
+
+	if err := tagMatchService.UpdateMatch(match.ID, models.TagMatchStateApplied, path); err != nil {
+		return nil, huma.Error500InternalServerError("Failed to update tag match: " + err.Error())
+	}
+
+	match, err = tagMatchService.GetMatch(match.ID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get tag match: " + err.Error())
+	}
+
+	return &TagMatchResponse{Body: *match}, nil
+}
+
+// RejectTagMatchHandler keeps the tags of the file, it won't be looked up again
+func RejectTagMatchHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*TagMatchResponse, error) {
+	match, err := getReviewableTagMatch(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := services.NewTagMatchService().UpdateMatch(match.ID, models.TagMatchStateRejected, match.Path); err != nil {
+		return nil, huma.Error500InternalServerError("Failed to update tag match: " + err.Error())
+	}
+
+	match.State = models.TagMatchStateRejected
+	return &TagMatchResponse{Body: *match}, nil
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+type TrackTags struct {
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	AlbumArtist string `json:"album_artist"`
+	Album       string `json:"album"`
+	TrackNumber int    `json:"track_number"`
+	DiscNumber  int    `json:"disc_number"`
+	Year        int    `json:"year"`
+	Genre       string `json:"genre"`
+	HasCover    bool   `json:"has_cover"`
+	// Whether the format supports writing tags
+	Writable bool `json:"writable"`
+}
+
+type TrackTagsResponse struct {
+	Body TrackTagsResponseBody
+}
+
+type TrackTagsResponseBody struct {
+	TrackID uint      `json:"track_id"`
+	Path    string    `json:"path"`
+	Tags    TrackTags `json:"tags"`
+}
+
+func readTrackTags(trackID uint, path string) (*TrackTagsResponse, error) {
+	metadata, err := utils.GetMetadataFromFile(path)
+	if err != nil {
+		return nil, huma.Error422UnprocessableEntity("Failed to read tags: " + err.Error())
+	}
+
+	trackNumber, _ := metadata.Track()
+	discNumber, _ := metadata.Disc()
+
+	return &TrackTagsResponse{
+		Body: TrackTagsResponseBody{
+			TrackID: trackID,
+			Path:    path,
+			Tags: TrackTags{
+				Title:       metadata.Title(),
+				Artist:      metadata.Artist(),
+				AlbumArtist: metadata.AlbumArtist(),
+				Album:       metadata.Album(),
+				TrackNumber: trackNumber,
+				DiscNumber:  discNumber,
+				Year:        metadata.Year(),
+				Genre:       metadata.Genre(),
+				HasCover:    metadata.Picture() != nil,
+				Writable:    utils.IsTagWritable(path),
+			},
+		},
+	}, nil
+}
+
+// GetTrackTagsHandler reads the tags straight from the audio file
+func GetTrackTagsHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*TrackTagsResponse, error) {
+	track, err := getLibraryTrack(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return readTrackTags(track.ID, track.Path)
+}
+
+// tags to write, omitted tags are kept
+type TrackTagsUpdate struct {
+	Title       *string `json:"title,omitempty"`
+	Artist      *string `json:"artist,omitempty"`
+	AlbumArtist *string `json:"album_artist,omitempty"`
+	Album       *string `json:"album,omitempty"`
+	TrackNumber *int    `json:"track_number,omitempty" minimum:"0"`
+	DiscNumber  *int    `json:"disc_number,omitempty" minimum:"0"`
+	Year        *int    `json:"year,omitempty" minimum:"0"`
+	Genre       *string `json:"genre,omitempty"`
+	Cover       []byte  `json:"cover,omitempty" doc:"Base64 encoded JPEG or PNG image replacing the embedded cover"`
+	// MusicBrainz identifiers, ignored for m4a files
+	MusicBrainzRecordingID *string `json:"musicbrainz_recording_id,omitempty"`
+	MusicBrainzReleaseID   *string `json:"musicbrainz_release_id,omitempty"`
+	MusicBrainzArtistID    *string `json:"musicbrainz_artist_id,omitempty"`
+	Sort                   bool    `json:"sort,omitempty" doc:"Move the file to the path matching its new tags"`
+}
+
+func (u TrackTagsUpdate) tagUpdate() utils.TagUpdate {
+	return utils.TagUpdate{
+		Title:       u.Title,
+		Artist:      u.Artist,
+		AlbumArtist: u.AlbumArtist,
+		Album:       u.Album,
+		TrackNumber: u.TrackNumber,
+		DiscNumber:  u.DiscNumber,
+		Year:        u.Year,
+		Genre:       u.Genre,
+		Cover:       u.Cover,
+
+		MusicBrainzRecordingID: u.MusicBrainzRecordingID,
+		MusicBrainzReleaseID:   u.MusicBrainzReleaseID,
+		MusicBrainzArtistID:    u.MusicBrainzArtistID,
+	}
+}
+
+type UpdateTrackTagsRequest struct {
+	ID   uint `required:"true" path:"id"`
+	Body TrackTagsUpdate
+}
+
+// writes the tags to the file of a track, sorts it if asked to and updates the library
+// returns the path of the file, which changed if it was sorted
+func updateTrackTags(track *models.Track, update TrackTagsUpdate) (string, error) {
+	if !utils.IsTagWritable(track.Path) {
+		return "", huma.Error422UnprocessableEntity("Writing tags is not supported for this format")
+	}
+
+	err := utils.WriteTags(track.Path, update.tagUpdate())
+
+	if errors.Is(err, utils.ErrUnsupportedTagFormat) || errors.Is(err, utils.ErrInvalidCover) {
+		return "", huma.Error422UnprocessableEntity(err.Error())
+	}
+
+	if err != nil {
+		return "", huma.Error500InternalServerError("Failed to write tags: " + err.Error())
+	}
+
+	path := track.Path
+	var sortErr error
+
+	if update.Sort {
+		path, sortErr = resortLibraryFile(track.Path)
+		if sortErr != nil {
+			path = track.Path
+		}
+	}
+
+	libraryService := services.NewLibraryService()
+
+	if path != track.Path {
+		err = libraryService.MoveTrack(track.Path, path)
+
+		if err := services.NewTagMatchService().UpdatePath(track.Path, path); err != nil {
+			log.Printf("Failed to update MusicBrainz match of %s: %v", track.Path, err)
+		}
+	} else {
+		err = libraryService.IndexFiles([]string{path})
+	}
+
+	if err != nil {
+		log.Printf("Failed to update library index for %s: %v", path, err)
+	}
+
+	refreshedPaths := []string{track.Path, path}
+
+	// playlists point at the old path
+	if path != track.Path {
+		refreshedPaths = append(refreshedPaths, writePlaylists()...)
+	}
+
+	refreshMediaServers(refreshedPaths)
+
+	// the tags were written, only the move failed
+	if sortErr != nil {
+		return path, huma.Error409Conflict("Tags were saved but the file could not be sorted: " + sortErr.Error())
+	}
+
+	return path, nil
+}
+
+// UpdateTrackTagsHandler writes the given tags to the audio file, omitted tags are kept
+func UpdateTrackTagsHandler(ctx context.Context, input *UpdateTrackTagsRequest) (*TrackTagsResponse, error) {
+	track, err := getLibraryTrack(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := updateTrackTags(track, input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return readTrackTags(track.ID, path)
+}
+
+type BulkUpdateTrackTagsResponse struct {
+	Body BulkUpdateTrackTagsResponseBody
+}
+
+type BulkUpdateTrackTagsResponseBody struct {
+	UpdatedTracks []uint `json:"updated_tracks"`
+	// key: track id, value: error message
+	Errors map[uint]string `json:"errors"`
+}
+
+// BulkUpdateTrackTagsHandler writes the same tags to several tracks, like an album artist to a whole album
+func BulkUpdateTrackTagsHandler(ctx context.Context, input *struct {
+	Body struct {
+		TrackIDs []uint `json:"track_ids" required:"true" minItems:"1"`
+		TrackTagsUpdate
+	}
+}) (*BulkUpdateTrackTagsResponse, error) {
+	res := &BulkUpdateTrackTagsResponse{
+		Body: BulkUpdateTrackTagsResponseBody{
+			UpdatedTracks: []uint{},
+			Errors:        map[uint]string{},
+		},
+	}
+
+	for _, trackID := range input.Body.TrackIDs {
+		track, err := getLibraryTrack(trackID)
+
+		if err == nil {
+			_, err = updateTrackTags(track, input.Body.TrackTagsUpdate)
+		}
+
+		if err != nil {
+			res.Body.Errors[trackID] = err.Error()
+			continue
+		}
+
+		res.Body.UpdatedTracks = append(res.Body.UpdatedTracks, trackID)
+	}
+
+	return res, nil
+}
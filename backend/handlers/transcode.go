@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+const (
+	defaultTranscodeBitrate = 128
+	minTranscodeBitrate     = 32
+	maxTranscodeBitrate     = 320
+)
+
+// transcodes in progress, concurrent requests for the same file wait for the first one
+// key: cache file path
+var transcodesInProgress = struct {
+	sync.Mutex
+	done map[string]chan struct{}
+}{done: map[string]chan struct{}{}}
+
+// the cache file name changes with the source file, outdated transcodes are never served
+func transcodeCachePath(path string, info os.FileInfo, format utils.TranscodeFormat, bitrate int) string {
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%s\x00%d", path, info.Size(), info.ModTime().UnixNano(), format.Codec, bitrate)
+	hash := sha256.Sum256([]byte(key))
+
+	return filepath.Join(utils.UserConfig.Transcoding.CacheDir, hex.EncodeToString(hash[:16])+format.Extension)
+}
+
+// returns the path of the transcoded track, transcoding it when it is not cached yet
+func getTranscodedTrack(track *models.Track, format utils.TranscodeFormat, bitrate int) (string, error) {
+	info, err := os.Stat(track.Path)
+	if err != nil {
+		return "", err
+	}
+
+	cachePath := transcodeCachePath(track.Path, info, format, bitrate)
+
+	for {
+		transcodesInProgress.Lock()
+		done, inProgress := transcodesInProgress.done[cachePath]
+
+		if !inProgress {
+			break
+		}
+
+		transcodesInProgress.Unlock()
+		<-done
+	}
+
+	// cache hit, the modification time keeps track of the last use for the eviction
+	if _, err := os.Stat(cachePath); err == nil {
+		transcodesInProgress.Unlock()
+		now := time.Now()
+		os.Chtimes(cachePath, now, now)
+		return cachePath, nil
+	}
+
+	done := make(chan struct{})
+	transcodesInProgress.done[cachePath] = done
+	transcodesInProgress.Unlock()
+
+	defer func() {
+		transcodesInProgress.Lock()
+		delete(transcodesInProgress.done, cachePath)
+		transcodesInProgress.Unlock()
+		close(done)
+	}()
+
+	if err := os.MkdirAll(utils.UserConfig.Transcoding.CacheDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	// hidden until complete, a failed transcode never ends up in the cache
+	tmpPath := filepath.Join(filepath.Dir(cachePath), "."+filepath.Base(cachePath)+".tmp")
+	defer os.Remove(tmpPath)
+
+	// not tied to the request, the result is cached even if the client leaves
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if err := utils.TranscodeAudio(ctx, track.Path, tmpPath, format, bitrate); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		return "", err
+	}
+
+	evictTranscodeCache(cachePath)
+
+	return cachePath, nil
+}
+
+// removes the least recently used transcodes until the cache fits in its size limit
+// keep is about to be served and is never removed
+func evictTranscodeCache(keep string) {
+	maxSize := utils.UserConfig.Transcoding.CacheMaxSizeMB * 1024 * 1024
+	if maxSize <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(utils.UserConfig.Transcoding.CacheDir)
+	if err != nil {
+		return
+	}
+
+	type cacheFile struct {
+		path    string
+		size    int64
+		lastUse time.Time
+	}
+
+	files := []cacheFile{}
+	totalSize := int64(0)
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, cacheFile{
+			path:    filepath.Join(utils.UserConfig.Transcoding.CacheDir, entry.Name()),
+			size:    info.Size(),
+			lastUse: info.ModTime(),
+		})
+		totalSize += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].lastUse.Before(files[j].lastUse)
+	})
+
+	for _, file := range files {
+		if totalSize <= maxSize {
+			break
+		}
+
+		if file.path == keep {
+			continue
+		}
+
+		if err := os.Remove(file.path); err != nil {
+			log.Printf("Failed to evict %s from the transcode cache: %v", file.path, err)
+			continue
+		}
+		totalSize -= file.size
+	}
+}
+
+// serves the track transcoded according to the format and bitrate query params
+func sendTranscodedTrack(c *fiber.Ctx, track *models.Track) error {
+	format, ok := utils.TranscodeFormats[c.Query("format")]
+	if !ok {
+		names := make([]string, 0, len(utils.TranscodeFormats))
+		for name := range utils.TranscodeFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid format, expected one of "+strings.Join(names, ", "))
+	}
+
+	bitrate := c.QueryInt("bitrate", defaultTranscodeBitrate)
+	if bitrate < minTranscodeBitrate || bitrate > maxTranscodeBitrate {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Bitrate must be between %d and %d kbit/s", minTranscodeBitrate, maxTranscodeBitrate))
+	}
+
+	cachePath, err := getTranscodedTrack(track, format, bitrate)
+	if err != nil {
+		log.Printf("Failed to transcode %s: %v", track.Path, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to transcode track")
+	}
+
+	return sendFileWithRanges(c, cachePath, format.ContentType)
+}
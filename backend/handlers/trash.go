@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+// hidden, so the library scan and the health report leave it alone
+func trashDir() string {
+	return filepath.Join(utils.UserConfig.OutputDir, ".trash")
+}
+
+// each entry has its own directory, files keep their path relative to the output dir
+func trashEntryDir(id uint) string {
+	return filepath.Join(trashDir(), strconv.FormatUint(uint64(id), 10))
+}
+
+// path of a library file relative to its output root
+// files of the other roots are prefixed with the root name, so they can't collide with the output dir ones
+func outputRelativePath(path string) (string, error) {
+	root, ok := utils.OutputRootOf(path)
+	if !ok {
+		return "", fmt.Errorf("%s is not in an output root", path)
+	}
+
+	relativePath, err := filepath.Rel(root.Dir, path)
+	if err != nil {
+		return "", err
+	}
+
+	if root.Name != utils.DefaultOutputRoot {
+		relativePath = filepath.Join(root.Name, relativePath)
+	}
+
+	return relativePath, nil
+}
+
+type TrashEntryResponse struct {
+	Body TrashEntryResponseBody
+}
+
+type TrashEntryResponseBody struct {
+	Entry *models.TrashEntry `json:"entry"`
+	// files that could not be moved to the trash and are still in the library
+	FilesWithErrors []string `json:"files_with_errors"`
+}
+
+// true when no audio file is left in dir
+func hasNoAudioFiles(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		if utils.IsAudioFileName(entry.Name()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// moves the tracks and their sidecars to the trash, recording their original paths
+// covers go with the last track of their folder
+func moveTracksToTrash(kind string, name string, tracks []models.Track) (*TrashEntryResponseBody, error) {
+	sortMutex.Lock()
+	defer sortMutex.Unlock()
+
+	trashService := services.NewTrashService()
+
+	entry := &models.TrashEntry{Kind: kind, Name: name}
+	if err := trashService.CreateEntry(entry); err != nil {
+		return nil, err
+	}
+
+	body := &TrashEntryResponseBody{Entry: entry, FilesWithErrors: []string{}}
+	// audio files that left the library
+	removedTracks := []string{}
+	dirs := []string{}
+
+	trashFile := func(path string) error {
+		relativePath, err := outputRelativePath(path)
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		trashPath := filepath.Join(trashEntryDir(entry.ID), relativePath)
+		if err := os.MkdirAll(filepath.Dir(trashPath), os.ModePerm); err != nil {
+			return err
+		}
+
+		if err := utils.MoveFile(path, trashPath, utils.MoveModeMove); err != nil {
+			return err
+		}
+
+		file := models.TrashFile{EntryID: entry.ID, OriginalPath: path, TrashPath: trashPath, Size: info.Size()}
+		if err := trashService.RecordFile(&file); err != nil {
+			log.Printf("Failed to record trashed file %s: %v", path, err)
+		}
+		entry.Files = append(entry.Files, file)
+
+		return nil
+	}
+
+	for _, track := range tracks {
+		sidecars, err := findSidecars(track.Path)
+		if err != nil {
+			log.Printf("Failed to find sidecars of %s: %v", track.Path, err)
+		}
+
+		if err := trashFile(track.Path); err != nil {
+			log.Printf("Failed to move %s to the trash: %v", track.Path, err)
+			body.FilesWithErrors = append(body.FilesWithErrors, track.Path)
+			continue
+		}
+		removedTracks = append(removedTracks, track.Path)
+
+		for _, sidecar := range sidecars {
+			if err := trashFile(sidecar); err != nil {
+				log.Printf("Failed to move %s to the trash: %v", sidecar, err)
+				body.FilesWithErrors = append(body.FilesWithErrors, sidecar)
+			}
+		}
+
+		if dir := filepath.Dir(track.Path); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		if !hasNoAudioFiles(dir) {
+			continue
+		}
+
+		if coverPath := utils.FindCoverFile(dir); coverPath != "" {
+			if err := trashFile(coverPath); err != nil {
+				log.Printf("Failed to move %s to the trash: %v", coverPath, err)
+				body.FilesWithErrors = append(body.FilesWithErrors, coverPath)
+			}
+		}
+
+		if root, ok := utils.OutputRootOf(dir); ok {
+			pruneEmptyParents(dir, root.Dir)
+		}
+	}
+
+	if len(entry.Files) == 0 {
+		if err := trashService.DeleteEntry(entry.ID); err != nil {
+			log.Printf("Failed to delete empty trash entry %d: %v", entry.ID, err)
+		}
+		return nil, errors.New("no file could be moved to the trash")
+	}
+
+	indexLibraryFiles(removedTracks)
+	refreshMediaServers(slices.Concat(removedTracks, writePlaylists()))
+
+	return body, nil
+}
+
+func trackDisplayName(track *models.Track) string {
+	if track.Artist == nil {
+		return track.Title
+	}
+	return track.Artist.Name + " - " + track.Title
+}
+
+// DeleteLibraryTrackHandler moves a track and its sidecars to the trash
+func DeleteLibraryTrackHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*TrashEntryResponse, error) {
+	track, err := getLibraryTrack(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := moveTracksToTrash(models.TrashKindTrack, trackDisplayName(track), []models.Track{*track})
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to move track to the trash: " + err.Error())
+	}
+
+	return &TrashEntryResponse{Body: *body}, nil
+}
+
+// DeleteLibraryAlbumHandler moves every track of an album to the trash, with the album cover
+func DeleteLibraryAlbumHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*TrashEntryResponse, error) {
+	libraryService := services.NewLibraryService()
+
+	album, err := libraryService.GetAlbum(input.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, huma.Error404NotFound("Album not found")
+	}
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get album: " + err.Error())
+	}
+
+	tracks, err := libraryService.GetAlbumTracks(album.ID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get album tracks: " + err.Error())
+	}
+
+	name := album.Title
+	if album.Artist != nil {
+		name = album.Artist.Name + " - " + album.Title
+	}
+
+	body, err := moveTracksToTrash(models.TrashKindAlbum, name, tracks)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to move album to the trash: " + err.Error())
+	}
+
+	return &TrashEntryResponse{Body: *body}, nil
+}
+
+type GetTrashResponse struct {
+	Body GetTrashResponseBody
+}
+
+type GetTrashResponseBody struct {
+	Entries []models.TrashEntry `json:"entries"`
+}
+
+// GetTrashHandler lists the deleted tracks and albums, most recent first
+func GetTrashHandler(ctx context.Context, input *struct{}) (*GetTrashResponse, error) {
+	entries, err := services.NewTrashService().GetAllEntries()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get trash: " + err.Error())
+	}
+
+	return &GetTrashResponse{
+		Body: GetTrashResponseBody{
+			Entries: entries,
+		},
+	}, nil
+}
+
+func getTrashEntry(id uint) (*models.TrashEntry, error) {
+	entry, err := services.NewTrashService().GetEntry(id)
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, huma.Error404NotFound("Trash entry not found")
+	}
+
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get trash entry: " + err.Error())
+	}
+
+	return entry, nil
+}
+
+type RestoreTrashEntryResponse struct {
+	Body RestoreTrashEntryResponseBody
+}
+
+type RestoreTrashEntryResponseBody struct {
+	RestoredFiles   []string `json:"restored_files"`
+	FilesWithErrors []string `json:"files_with_errors"`
+}
+
+// RestoreTrashEntryHandler moves the files of a trash entry back to their original paths
+// The restore is refused as a whole when a file now exists at one of the original paths
+func RestoreTrashEntryHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*RestoreTrashEntryResponse, error) {
+	entry, err := getTrashEntry(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []error{}
+	for _, file := range entry.Files {
+		if _, err := os.Lstat(file.OriginalPath); err == nil {
+			conflicts = append(conflicts, &huma.ErrorDetail{
+				Message:  "a file already exists at the original path",
+				Location: file.OriginalPath,
+				Value:    file.TrashPath,
+			})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, huma.Error409Conflict("Some files were replaced since they were deleted, nothing was restored", conflicts...)
+	}
+
+	sortMutex.Lock()
+	defer sortMutex.Unlock()
+
+	trashService := services.NewTrashService()
+	restoredFiles := []string{}
+	filesWithErrors := []string{}
+
+	for _, file := range entry.Files {
+		err := os.MkdirAll(filepath.Dir(file.OriginalPath), os.ModePerm)
+		if err == nil {
+			err = utils.MoveFile(file.TrashPath, file.OriginalPath, utils.MoveModeMove)
+		}
+
+		if err != nil {
+			log.Printf("Failed to restore %s: %v", file.OriginalPath, err)
+			filesWithErrors = append(filesWithErrors, file.OriginalPath)
+			continue
+		}
+
+		if err := trashService.DeleteFile(file.ID); err != nil {
+			log.Printf("Failed to delete trashed file record %d: %v", file.ID, err)
+		}
+		restoredFiles = append(restoredFiles, file.OriginalPath)
+	}
+
+	if len(filesWithErrors) == 0 {
+		if err := purgeTrashEntry(entry.ID); err != nil {
+			log.Printf("Failed to remove trash entry %d: %v", entry.ID, err)
+		}
+	}
+
+	indexLibraryFiles(restoredFiles)
+
+	if len(restoredFiles) > 0 {
+		refreshMediaServers(slices.Concat(restoredFiles, writePlaylists()))
+	}
+
+	return &RestoreTrashEntryResponse{
+		Body: RestoreTrashEntryResponseBody{
+			RestoredFiles:   restoredFiles,
+			FilesWithErrors: filesWithErrors,
+		},
+	}, nil
+}
+
+// removes the files of a trash entry for good
+func purgeTrashEntry(id uint) error {
+	if err := os.RemoveAll(trashEntryDir(id)); err != nil {
+		return err
+	}
+
+	return services.NewTrashService().DeleteEntry(id)
+}
+
+// PurgeTrashEntryHandler permanently deletes the files of a trash entry
+func PurgeTrashEntryHandler(ctx context.Context, input *struct {
+	ID uint `required:"true" path:"id"`
+}) (*struct{}, error) {
+	entry, err := getTrashEntry(input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := purgeTrashEntry(entry.ID); err != nil {
+		return nil, huma.Error500InternalServerError("Failed to purge trash entry: " + err.Error())
+	}
+
+	return nil, nil
+}
+
+// permanently deletes the trash entries older than the trash retention
+// returns the number of purged entries
+func purgeExpiredTrash() (int, error) {
+	if utils.UserConfig.Trash.RetentionDays <= 0 {
+		return 0, nil
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -utils.UserConfig.Trash.RetentionDays)
+	entries, err := services.NewTrashService().GetEntriesBefore(cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, entry := range entries {
+		if err := purgeTrashEntry(entry.ID); err != nil {
+			log.Printf("Failed to purge trash entry %d: %v", entry.ID, err)
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicolassutter/scyd/utils"
+)
+
+// collects the files dropped into the downloads dir and sorts them once they stop changing
+type downloadsWatcher struct {
+	mu sync.Mutex
+	// top level entries of the downloads dir that changed since the last sort
+	pending map[string]bool
+	timer   *time.Timer
+}
+
+// files an undo moved back into the downloads dir, the watcher leaves them alone until they change
+// key: path, value: modification time once restored
+var restoredFiles = struct {
+	sync.Mutex
+	modTimes map[string]time.Time
+}{modTimes: map[string]time.Time{}}
+
+// keeps the watcher from sorting again the files an undo just put back
+func ignoreRestoredFiles(paths []string) {
+	if !utils.UserConfig.Watcher.Enabled {
+		return
+	}
+
+	restoredFiles.Lock()
+	defer restoredFiles.Unlock()
+
+	for _, path := range paths {
+		if !isWithinAny(path, []string{utils.UserConfig.DownloadDir}) {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			restoredFiles.modTimes[path] = info.ModTime()
+		}
+	}
+}
+
+// reports whether the file was restored by an undo and hasn't changed since
+func isRestoredFile(path string) bool {
+	restoredFiles.Lock()
+	defer restoredFiles.Unlock()
+
+	modTime, exists := restoredFiles.modTimes[path]
+	if !exists {
+		return false
+	}
+
+	if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(modTime) {
+		delete(restoredFiles.modTimes, path)
+		return false
+	}
+
+	return true
+}
+
+func (w *downloadsWatcher) debounce() time.Duration {
+	return time.Duration(utils.UserConfig.Watcher.DebounceSeconds) * time.Second
+}
+
+func (w *downloadsWatcher) onChange(path string) {
+	downloadDir := utils.UserConfig.DownloadDir
+
+	relativePath, err := filepath.Rel(downloadDir, path)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return
+	}
+
+	// hidden files and directories are scyd's own (quarantine, tag rewrites...) or still being written
+	for _, component := range strings.Split(relativePath, string(filepath.Separator)) {
+		if component != "." && strings.HasPrefix(component, ".") {
+			return
+		}
+	}
+
+	if isPartialFile(filepath.Base(path)) {
+		return
+	}
+
+	// running downloads are sorted once they complete, their files are not ours to pick up
+	if downloadManager.IsDownloadOutput(path) || isRestoredFile(path) {
+		return
+	}
+
+	// "." when events were lost, the whole directory is sorted
+	entry := strings.Split(relativePath, string(filepath.Separator))[0]
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending[filepath.Join(downloadDir, entry)] = true
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.debounce(), w.flush)
+	} else {
+		w.timer.Reset(w.debounce())
+	}
+}
+
+func (w *downloadsWatcher) flush() {
+	w.mu.Lock()
+
+	// files dropped in during a download are held until it completes, it sorts the directory itself
+	if downloadManager.HasActiveDownloads() {
+		w.timer.Reset(w.debounce())
+		w.mu.Unlock()
+		return
+	}
+
+	paths := make([]string, 0, len(w.pending))
+	for path := range w.pending {
+		paths = append(paths, path)
+	}
+	w.pending = map[string]bool{}
+	w.mu.Unlock()
+
+	if len(paths) == 0 {
+		return
+	}
+
+	// the files of the entries that an undo put back stay where they are
+	res, err := SortDownloadPaths(paths, isRestoredFile)
+	if err != nil {
+		utils.ExecuteCommandBg(utils.UserConfig.Hooks.OnError)
+		log.Printf("Failed to sort files dropped into %s: %v", utils.UserConfig.DownloadDir, err)
+		return
+	}
+
+	if len(res.Body.MovedFiles) > 0 || len(res.Body.FilesWithErrors) > 0 {
+		log.Printf("Sorted %d files dropped into %s, %d errors", len(res.Body.MovedFiles), utils.UserConfig.DownloadDir, len(res.Body.FilesWithErrors))
+	}
+}
+
+// StartDownloadsWatcher sorts files copied into the downloads dir by hand, when enabled in the config
+func StartDownloadsWatcher() {
+	if !utils.UserConfig.Watcher.Enabled {
+		return
+	}
+
+	watcher := &downloadsWatcher{pending: map[string]bool{}}
+
+	err := utils.WatchDirectory(context.Background(), utils.UserConfig.DownloadDir, watcher.onChange)
+	if err != nil {
+		log.Printf("Failed to watch %s: %v", utils.UserConfig.DownloadDir, err)
+		return
+	}
+
+	log.Printf("Watching %s for new files", utils.UserConfig.DownloadDir)
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/services"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+type zipEntry struct {
+	path string
+	// path inside the archive
+	name string
+}
+
+// the files of the archive, tracks first then the cover of each folder
+func zipEntries(tracks []models.Track) []zipEntry {
+	entries := []zipEntry{}
+	dirs := []string{}
+
+	archivePath := func(path string) string {
+		relativePath, err := outputRelativePath(path)
+		if err != nil {
+			return filepath.Base(path)
+		}
+		return filepath.ToSlash(relativePath)
+	}
+
+	for _, track := range tracks {
+		entries = append(entries, zipEntry{path: track.Path, name: archivePath(track.Path)})
+
+		if dir := filepath.Dir(track.Path); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		if coverPath := utils.FindCoverFile(dir); coverPath != "" {
+			entries = append(entries, zipEntry{path: coverPath, name: archivePath(coverPath)})
+		}
+	}
+
+	return entries
+}
+
+func addFileToZip(archive *zip.Writer, file *os.File, name string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// audio and images are already compressed
+	header.Method = zip.Store
+
+	writer, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// the tracks selected by the album_id, artist_id or track_ids query params, with the name of the archive
+func zipSelection(c *fiber.Ctx) ([]models.Track, string, error) {
+	libraryService := services.NewLibraryService()
+
+	switch {
+	case c.Query("album_id") != "":
+		id, err := strconv.ParseUint(c.Query("album_id"), 10, 0)
+		if err != nil {
+			return nil, "", fiber.NewError(fiber.StatusBadRequest, "Invalid album ID")
+		}
+
+		album, err := libraryService.GetAlbum(uint(id))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", fiber.NewError(fiber.StatusNotFound, "Album not found")
+		}
+		if err != nil {
+			return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Failed to get album")
+		}
+
+		tracks, err := libraryService.GetAlbumTracks(album.ID)
+		name := album.Title
+		if album.Artist != nil {
+			name = album.Artist.Name + " - " + album.Title
+		}
+		return tracks, name, err
+
+	case c.Query("artist_id") != "":
+		id, err := strconv.ParseUint(c.Query("artist_id"), 10, 0)
+		if err != nil {
+			return nil, "", fiber.NewError(fiber.StatusBadRequest, "Invalid artist ID")
+		}
+
+		artist, err := libraryService.GetArtist(uint(id))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", fiber.NewError(fiber.StatusNotFound, "Artist not found")
+		}
+		if err != nil {
+			return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Failed to get artist")
+		}
+
+		tracks, err := libraryService.GetArtistTracks(artist.ID)
+		return tracks, artist.Name, err
+
+	case c.Query("track_ids") != "":
+		ids := []uint{}
+		for _, value := range strings.Split(c.Query("track_ids"), ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(value), 10, 0)
+			if err != nil {
+				return nil, "", fiber.NewError(fiber.StatusBadRequest, "Invalid track ID "+value)
+			}
+			ids = append(ids, uint(id))
+		}
+
+		tracks, err := libraryService.GetTracksByIDs(ids)
+		return tracks, "scyd", err
+	}
+
+	return nil, "", fiber.NewError(fiber.StatusBadRequest, "One of album_id, artist_id or track_ids is required")
+}
+
+// DownloadLibraryZipHandler streams a ZIP of an album, an artist or a list of tracks with their covers
+// The archive is written on the fly, files keep their folder layout of the output dir
+// This is a plain Fiber handler because Huma responses can't be streamed
+func DownloadLibraryZipHandler(c *fiber.Ctx) error {
+	tracks, name, err := zipSelection(c)
+	if err != nil {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			return err
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to get tracks")
+	}
+
+	if len(tracks) == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "No tracks found")
+	}
+
+	fileName := sanitizePathComponent(name) + ".zip"
+	entries := zipEntries(tracks)
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename=%q; filename*=UTF-8''%s`, fileName, url.PathEscape(fileName)))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		archive := zip.NewWriter(w)
+
+		for _, entry := range entries {
+			// files removed since the selection are left out
+			file, err := os.Open(entry.path)
+			if err != nil {
+				log.Printf("Failed to add %s to zip: %v", entry.path, err)
+				continue
+			}
+
+			err = addFileToZip(archive, file, entry.name)
+			file.Close()
+
+			// the headers are sent already, a truncated archive is all the client can be told
+			if err != nil {
+				log.Printf("Failed to add %s to zip: %v", entry.path, err)
+				return
+			}
+		}
+
+		if err := archive.Close(); err != nil {
+			log.Printf("Failed to finish zip: %v", err)
+		}
+	})
+
+	return nil
+}
@@ -23,6 +23,15 @@ func main() {
 		log.Fatalf("Error initializing database: %s", err)
 	}
 
+	// index the output dir, files sorted while scyd was down are picked up here
+	handlers.ScanLibraryInBackground()
+
+	// sorts files copied into the downloads dir by hand
+	handlers.StartDownloadsWatcher()
+
+	// applies the retention policies of the download history, download leftovers and trash
+	handlers.StartMaintenanceScheduler()
+
 	fiberApp := fiber.New()
 
 	if utils.IsDevelopment() {
@@ -37,12 +46,15 @@ func main() {
 	// Register global middleware that allows to access the Fiber context from Huma handlers
 	api.UseMiddleware(utils.StoreFiberCtx)
 
+	// operations are restricted by the role of the user and the scopes of API tokens
+	api.UseMiddleware(handlers.CheckPermissions(api))
+
 	api_v1 := huma.NewGroup(api, "/api/v1")
 
 	// Auth routes (public) - now using Huma
-	huma.Post(api_v1, "/auth/login", handlers.LoginHandler)
-	huma.Post(api_v1, "/auth/logout", handlers.LogoutHandler)
-	huma.Get(api_v1, "/auth/status", handlers.AuthStatusHandler)
+	huma.Post(api_v1, "/auth/login", handlers.LoginHandler, handlers.Public)
+	huma.Post(api_v1, "/auth/logout", handlers.LogoutHandler, handlers.Public)
+	huma.Get(api_v1, "/auth/status", handlers.AuthStatusHandler, handlers.Public)
 
 	// health check route
 	type statusResponse struct {
@@ -54,23 +66,69 @@ func main() {
 				"status": "ok",
 			},
 		}, nil
-	})
+	}, handlers.Public)
 
 	// Protected routes (require authentication)
 	// Apply auth middleware to the Huma API routes by using Fiber middleware
 	// Huma is protected as well because it's mounted on the Fiber app
 	fiberApiV1 := fiberApp.Group("/api/v1", handlers.AuthMiddleware())
 
+	// Personal API tokens (protected), managed with the session cookie only
+	huma.Post(api_v1, "/auth/tokens", handlers.CreateAPITokenHandler, handlers.RequireSession)
+	huma.Get(api_v1, "/auth/tokens", handlers.GetAPITokensHandler, handlers.RequireSession)
+	huma.Delete(api_v1, "/auth/tokens/{id}", handlers.RevokeAPITokenHandler, handlers.RequireSession)
+
 	// Download routes (protected)
-	huma.Post(api_v1, "/download", handlers.DownloadHandler)
-	huma.Post(api_v1, "/download/cancel/{id}", handlers.CancelDownloadHandler)
-	huma.Delete(api_v1, "/download/{id}", handlers.DeleteDownloadHandler)
-	huma.Post(api_v1, "/sort-downloads", handlers.SortDownloadsHandler)
-	huma.Get(api_v1, "/downloads", handlers.GetDownloadsHandler)
+	huma.Post(api_v1, "/download", handlers.DownloadHandler, handlers.RequireScope(handlers.ScopeDownloadCreate))
+	huma.Post(api_v1, "/download/cancel/{id}", handlers.CancelDownloadHandler, handlers.RequireScope(handlers.ScopeDownloadManage))
+	huma.Delete(api_v1, "/download/{id}", handlers.DeleteDownloadHandler, handlers.RequireScope(handlers.ScopeDownloadManage))
+	huma.Post(api_v1, "/sort-downloads", handlers.SortDownloadsHandler, handlers.RequireScope(handlers.ScopeSort))
+	huma.Get(api_v1, "/downloads", handlers.GetDownloadsHandler, handlers.RequireScope(handlers.ScopeDownloadRead))
+
+	// Sort journal routes (protected)
+	huma.Get(api_v1, "/sort-runs", handlers.GetSortRunsHandler, handlers.RequireScope(handlers.ScopeSort))
+	huma.Get(api_v1, "/sort-runs/{id}", handlers.GetSortRunHandler, handlers.RequireScope(handlers.ScopeSort))
+	huma.Post(api_v1, "/sort-runs/{id}/undo", handlers.UndoSortRunHandler, handlers.RequireScope(handlers.ScopeSort))
+
+	// MusicBrainz review queue (protected)
+	huma.Get(api_v1, "/tag-matches", handlers.GetTagMatchesHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+	huma.Post(api_v1, "/tag-matches/{id}/apply", handlers.ApplyTagMatchHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+	huma.Post(api_v1, "/tag-matches/{id}/reject", handlers.RejectTagMatchHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+
+	// Library routes (protected)
+	huma.Post(api_v1, "/library/scan", handlers.ScanLibraryHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+	huma.Get(api_v1, "/library/tracks", handlers.GetLibraryTracksHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+	huma.Get(api_v1, "/library/tracks/{id}", handlers.GetLibraryTrackHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+	huma.Get(api_v1, "/library/tracks/{id}/tags", handlers.GetTrackTagsHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+	huma.Patch(api_v1, "/library/tracks/{id}/tags", handlers.UpdateTrackTagsHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+	huma.Patch(api_v1, "/library/tracks/tags", handlers.BulkUpdateTrackTagsHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+	huma.Get(api_v1, "/library/health", handlers.GetLibraryHealthHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+	huma.Post(api_v1, "/library/health/fix", handlers.FixLibraryHealthHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+	huma.Get(api_v1, "/library/albums", handlers.GetLibraryAlbumsHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+	huma.Get(api_v1, "/library/artists", handlers.GetLibraryArtistsHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+	huma.Delete(api_v1, "/library/tracks/{id}", handlers.DeleteLibraryTrackHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+	huma.Delete(api_v1, "/library/albums/{id}", handlers.DeleteLibraryAlbumHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+
+	// Trash of deleted tracks and albums (protected)
+	huma.Get(api_v1, "/trash", handlers.GetTrashHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+	huma.Post(api_v1, "/trash/{id}/restore", handlers.RestoreTrashEntryHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+	huma.Delete(api_v1, "/trash/{id}", handlers.PurgeTrashEntryHandler, handlers.RequireScope(handlers.ScopeLibraryWrite))
+
+	// Disk usage (protected)
+	huma.Get(api_v1, "/storage", handlers.GetStorageHandler, handlers.RequireScope(handlers.ScopeLibraryRead))
+
+	// Retention policies (protected)
+	huma.Post(api_v1, "/maintenance/run", handlers.RunMaintenanceHandler, handlers.RequireScope(handlers.ScopeMaintenance))
 
 	// Setup WebSocket for real-time download updates
 	handlers.SetupDownloadWebSocket(&fiberApiV1)
 
+	// Audio streaming with byte ranges (protected)
+	fiberApiV1.Get("/library/tracks/:id/stream", handlers.RequireScopeHandler(handlers.ScopeLibraryRead), handlers.StreamTrackHandler)
+
+	// ZIP of an album, an artist or a selection of tracks (protected)
+	fiberApiV1.Get("/library/zip", handlers.RequireScopeHandler(handlers.ScopeLibraryRead), handlers.DownloadLibraryZipHandler)
+
 	if !utils.IsDevelopment() {
 		fmt.Println("Running in production mode, serving static files from ./public")
 
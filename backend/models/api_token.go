@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// a personal access token, sent by automation clients as "Authorization: Bearer <token>"
+type APIToken struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Username string `gorm:"index;not null" json:"username"`
+	Name     string `gorm:"not null" json:"name"`
+	// sha256 of the token, the token itself is only shown once when it is created
+	TokenHash string `gorm:"uniqueIndex;not null" json:"-"`
+	// first characters of the token, to tell tokens apart
+	Prefix string `json:"prefix"`
+	// empty for a token allowed to do everything its user can
+	Scopes []string `gorm:"serializer:json" json:"scopes"`
+	// nil for a token that never expires
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
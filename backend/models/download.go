@@ -15,9 +15,11 @@ const (
 	DownloadStateError    DownloadState = "error"
 )
 
+// a yt-dlp download, Username is the user who started it (empty for downloads started before owners were recorded)
 type Download struct {
 	ID           uint           `gorm:"primaryKey" json:"id"`
 	URL          string         `gorm:"not null" json:"url"`
+	Username     string         `gorm:"index;default:''" json:"username"`
 	State        DownloadState  `gorm:"default:pending" json:"state"`
 	ErrorMessage string         `gorm:"default:''" json:"error_message"`
 	CreatedAt    time.Time      `json:"created_at"`
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+)
+
+type Artist struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// an album is identified by its title and album artist
+type Album struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Title     string    `gorm:"uniqueIndex:idx_album_artist;not null" json:"title"`
+	ArtistID  uint      `gorm:"uniqueIndex:idx_album_artist;not null" json:"artist_id"`
+	Artist    *Artist   `json:"artist,omitempty"`
+	Year      int       `json:"year"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// an audio file of the library in the output dir
+type Track struct {
+	ID       uint    `gorm:"primaryKey" json:"id"`
+	Path     string  `gorm:"uniqueIndex;not null" json:"path"`
+	Title    string  `gorm:"index" json:"title"`
+	ArtistID uint    `gorm:"index;not null" json:"artist_id"`
+	Artist   *Artist `json:"artist,omitempty"`
+	// nil for tracks without an album tag
+	AlbumID     *uint  `gorm:"index" json:"album_id"`
+	Album       *Album `json:"album,omitempty"`
+	TrackNumber int    `json:"track_number"`
+	DiscNumber  int    `json:"disc_number"`
+	Year        int    `gorm:"index" json:"year"`
+	Genre       string `gorm:"index" json:"genre"`
+	Format      string `json:"format"`
+	// audio properties, zero when ffprobe is not installed
+	Duration   float64 `json:"duration" doc:"In seconds"`
+	Bitrate    int     `json:"bitrate" doc:"In bit/s"`
+	SampleRate int     `json:"sample_rate" doc:"In Hz"`
+	Codec      string  `json:"codec"`
+	HasCover   bool    `json:"has_cover"`
+	// the file has no title tag, Title is its file name
+	Untitled bool `json:"untitled"`
+	// provenance of downloaded tracks
+	Extractor string `json:"extractor"`
+	SourceID  string `json:"source_id"`
+	SourceURL string `json:"source_url"`
+	// size and modification time of the file when it was indexed, used to skip unchanged files
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// files indexed by an older version are read again
+	IndexVersion int       `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
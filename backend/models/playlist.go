@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// a playlist (YouTube playlist, SoundCloud set...) that tracks were downloaded from
+type Playlist struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	Extractor string `gorm:"uniqueIndex:idx_playlist_source;not null" json:"extractor"`
+	SourceID  string `gorm:"uniqueIndex:idx_playlist_source;not null" json:"source_id"`
+	Title     string `json:"title"`
+	// name of the m3u8 file last written for this playlist
+	FileName  string          `json:"file_name"`
+	Entries   []PlaylistEntry `gorm:"foreignKey:PlaylistID" json:"entries,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// a track of a playlist at its original position
+// tracks are matched to the library by where they were downloaded from
+type PlaylistEntry struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	PlaylistID uint   `gorm:"index;not null" json:"playlist_id"`
+	Position   int    `gorm:"not null" json:"position"`
+	Extractor  string `gorm:"not null" json:"extractor"`
+	SourceID   string `gorm:"not null" json:"source_id"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+type SortRunState string
+
+const (
+	SortRunStateCompleted SortRunState = "completed"
+	SortRunStateUndone    SortRunState = "undone"
+)
+
+// a single invocation of the downloads sorting that moved at least one file
+type SortRun struct {
+	ID          uint         `gorm:"primaryKey" json:"id"`
+	State       SortRunState `gorm:"default:completed" json:"state"`
+	Moves       []SortMove   `gorm:"foreignKey:RunID" json:"moves,omitempty"`
+	CreatedDirs []SortRunDir `gorm:"foreignKey:RunID" json:"created_dirs,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// mode of journaled files that were written by the sort run rather than moved, like album covers
+const SortMoveModeCreated = "created"
+
+// a file moved by a sort run
+// size and modification time of the destination are kept to detect later changes
+type SortMove struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	RunID       uint       `gorm:"index;not null" json:"run_id"`
+	Source      string     `gorm:"not null" json:"source"`
+	Destination string     `gorm:"not null" json:"destination"`
+	Mode        string     `gorm:"not null" json:"mode"`
+	Size        int64      `json:"size"`
+	ModTime     time.Time  `json:"mod_time"`
+	UndoneAt    *time.Time `json:"undone_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// a directory that did not exist before the sort run created it
+type SortRunDir struct {
+	ID    uint   `gorm:"primaryKey" json:"id"`
+	RunID uint   `gorm:"index;not null" json:"run_id"`
+	Path  string `gorm:"not null" json:"path"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+type TagMatchState string
+
+const (
+	// waiting for a review, the confidence was below the threshold
+	TagMatchStatePending  TagMatchState = "pending"
+	TagMatchStateApplied  TagMatchState = "applied"
+	TagMatchStateRejected TagMatchState = "rejected"
+	// nothing was found, kept so the file is not looked up again
+	TagMatchStateNoMatch TagMatchState = "no_match"
+)
+
+// the MusicBrainz recording found for an audio file
+type TagMatch struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	// current path of the file, follows it when it is sorted
+	Path  string        `gorm:"index;not null" json:"path"`
+	State TagMatchState `gorm:"index;not null" json:"state"`
+	// 0 to 100
+	Confidence int `json:"confidence"`
+	// musicbrainz (search by tags) or acoustid (fingerprint)
+	Method string `json:"method"`
+	// tags of the file when it was looked up
+	OriginalArtist string `json:"original_artist"`
+	OriginalTitle  string `json:"original_title"`
+	// canonical tags of the match
+	Title       string    `json:"title"`
+	Artist      string    `json:"artist"`
+	AlbumArtist string    `json:"album_artist"`
+	Album       string    `json:"album"`
+	TrackNumber int       `json:"track_number"`
+	DiscNumber  int       `json:"disc_number"`
+	Year        int       `json:"year"`
+	RecordingID string    `json:"recording_id"`
+	ReleaseID   string    `json:"release_id"`
+	ArtistID    string    `json:"artist_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
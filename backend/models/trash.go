@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	TrashKindTrack = "track"
+	TrashKindAlbum = "album"
+)
+
+// files removed from the library together, they are restored together
+type TrashEntry struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	// track or album
+	Kind string `gorm:"not null" json:"kind"`
+	// "Artist - Title" of a track, "Artist - Album" of an album
+	Name  string      `json:"name"`
+	Files []TrashFile `gorm:"foreignKey:EntryID" json:"files,omitempty"`
+	// when the files were moved to the trash
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// a file moved from the library to the trash
+type TrashFile struct {
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	EntryID      uint   `gorm:"index;not null" json:"entry_id"`
+	OriginalPath string `gorm:"not null" json:"original_path"`
+	TrashPath    string `gorm:"not null" json:"trash_path"`
+	Size         int64  `json:"size"`
+}
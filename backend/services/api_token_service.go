@@ -0,0 +1,53 @@
+package services
+
+import (
+	"time"
+
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+type APITokenService struct{}
+
+func NewAPITokenService() *APITokenService {
+	return &APITokenService{}
+}
+
+func (ats *APITokenService) CreateToken(token *models.APIToken) error {
+	return utils.DB.Create(token).Error
+}
+
+// returns the tokens of a user, most recent first
+func (ats *APITokenService) GetUserTokens(username string) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	result := utils.DB.Where("username = ?", username).Order("created_at DESC").Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tokens, nil
+}
+
+func (ats *APITokenService) GetTokenByHash(hash string) (*models.APIToken, error) {
+	var token models.APIToken
+	result := utils.DB.Where("token_hash = ?", hash).First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &token, nil
+}
+
+func (ats *APITokenService) TouchToken(id uint, usedAt time.Time) error {
+	return utils.DB.Model(&models.APIToken{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
+
+// deletes a token of the user, returns false when the user has no such token
+func (ats *APITokenService) DeleteUserToken(id uint, username string) (bool, error) {
+	result := utils.DB.Where("id = ? AND username = ?", id, username).Delete(&models.APIToken{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
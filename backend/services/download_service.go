@@ -1,6 +1,8 @@
 package services
 
 import (
+	"time"
+
 	"github.com/nicolassutter/scyd/models"
 	"github.com/nicolassutter/scyd/utils"
 )
@@ -11,10 +13,11 @@ func NewDownloadService() *DownloadService {
 	return &DownloadService{}
 }
 
-func (ds *DownloadService) CreateDownload(url string) (*models.Download, error) {
+func (ds *DownloadService) CreateDownload(url string, username string) (*models.Download, error) {
 	download := &models.Download{
-		URL:   url,
-		State: models.DownloadStatePending,
+		URL:      url,
+		Username: username,
+		State:    models.DownloadStatePending,
 	}
 
 	result := utils.DB.Create(download)
@@ -58,3 +61,38 @@ func (ds *DownloadService) GetAllDownloads() ([]models.Download, error) {
 
 	return downloads, nil
 }
+
+// returns the downloads started by a user, most recent first
+func (ds *DownloadService) GetUserDownloads(username string) ([]models.Download, error) {
+	var downloads []models.Download
+	result := utils.DB.Where("username = ?", username).Order("created_at DESC").Find(&downloads)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return downloads, nil
+}
+
+// PurgeDownloads permanently removes successful downloads finished before the given time
+// and the downloads that were deleted from the history
+// returns the number of purged successful and deleted downloads
+func (ds *DownloadService) PurgeDownloads(successfulBefore *time.Time) (int64, int64, error) {
+	purgedSuccessful := int64(0)
+
+	if successfulBefore != nil {
+		result := utils.DB.Unscoped().
+			Where("state = ? AND updated_at < ?", models.DownloadStateSuccess, *successfulBefore).
+			Delete(&models.Download{})
+		if result.Error != nil {
+			return 0, 0, result.Error
+		}
+		purgedSuccessful = result.RowsAffected
+	}
+
+	result := utils.DB.Unscoped().Where("deleted_at IS NOT NULL").Delete(&models.Download{})
+	if result.Error != nil {
+		return purgedSuccessful, 0, result.Error
+	}
+
+	return purgedSuccessful, result.RowsAffected, nil
+}
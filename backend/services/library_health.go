@@ -0,0 +1,122 @@
+package services
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+type TrackHealthIssues struct {
+	MissingArtist []models.Track
+	MissingAlbum  []models.Track
+	MissingTitle  []models.Track
+	MissingCover  []models.Track
+	// groups of tracks that are likely the same recording: same artist, title and duration
+	Duplicates [][]models.Track
+}
+
+// GetTrackHealthIssues returns the indexed tracks with missing tags or likely duplicates
+func (ls *LibraryService) GetTrackHealthIssues() (*TrackHealthIssues, error) {
+	var tracks []models.Track
+	err := utils.DB.Preload("Artist").Preload("Album").Order("tracks.path ASC").Find(&tracks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	issues := &TrackHealthIssues{
+		MissingArtist: []models.Track{},
+		MissingAlbum:  []models.Track{},
+		MissingTitle:  []models.Track{},
+		MissingCover:  []models.Track{},
+		Duplicates:    [][]models.Track{},
+	}
+
+	// key: artist id and lowercase title
+	type duplicateKey struct {
+		artistID uint
+		title    string
+	}
+	candidates := map[duplicateKey][]models.Track{}
+	candidateKeys := []duplicateKey{}
+
+	for _, track := range tracks {
+		if track.Artist == nil || track.Artist.Name == UnknownArtistName {
+			issues.MissingArtist = append(issues.MissingArtist, track)
+		}
+		if track.AlbumID == nil {
+			issues.MissingAlbum = append(issues.MissingAlbum, track)
+		}
+		if track.Untitled {
+			issues.MissingTitle = append(issues.MissingTitle, track)
+		}
+		if !track.HasCover {
+			issues.MissingCover = append(issues.MissingCover, track)
+		}
+
+		// file names are no proof of being the same recording
+		if track.Untitled {
+			continue
+		}
+
+		key := duplicateKey{artistID: track.ArtistID, title: strings.ToLower(strings.TrimSpace(track.Title))}
+		if _, ok := candidates[key]; !ok {
+			candidateKeys = append(candidateKeys, key)
+		}
+		candidates[key] = append(candidates[key], track)
+	}
+
+	for _, key := range candidateKeys {
+		for _, group := range splitByDuration(candidates[key]) {
+			if len(group) > 1 {
+				issues.Duplicates = append(issues.Duplicates, group)
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// recordings of the same title further apart than this are different versions (live, remix, radio edit...)
+const duplicateDurationTolerance = 3.0
+
+// splits tracks with the same artist and title into groups of similar durations
+// tracks of unknown duration can't be told apart, the group is kept whole
+func splitByDuration(tracks []models.Track) [][]models.Track {
+	for _, track := range tracks {
+		if track.Duration == 0 {
+			return [][]models.Track{tracks}
+		}
+	}
+
+	sorted := append([]models.Track{}, tracks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Duration < sorted[j].Duration
+	})
+
+	groups := [][]models.Track{}
+	for i, track := range sorted {
+		if i == 0 || track.Duration-sorted[i-1].Duration > duplicateDurationTolerance {
+			groups = append(groups, []models.Track{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], track)
+	}
+
+	return groups
+}
+
+// GetTrackPaths returns the paths of every indexed track
+func (ls *LibraryService) GetTrackPaths() (map[string]bool, error) {
+	var paths []string
+	if err := utils.DB.Model(&models.Track{}).Pluck("path", &paths).Error; err != nil {
+		return nil, err
+	}
+
+	trackPaths := map[string]bool{}
+	for _, path := range paths {
+		trackPaths[path] = true
+	}
+
+	return trackPaths, nil
+}
@@ -0,0 +1,505 @@
+package services
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+type LibraryService struct{}
+
+func NewLibraryService() *LibraryService {
+	return &LibraryService{}
+}
+
+// artist of the tracks without an artist tag
+const UnknownArtistName = "Unknown Artist"
+
+// bumped when indexFile reads more from the files, every track is then read again
+const trackIndexVersion = 2
+
+// only one scan can touch the library tables at a time
+var libraryScanMutex sync.Mutex
+
+type LibraryScanResult struct {
+	Indexed   int `json:"indexed"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+}
+
+// ScanLibrary indexes every audio file of the output roots and removes tracks whose file is gone
+func (ls *LibraryService) ScanLibrary() (*LibraryScanResult, error) {
+	libraryScanMutex.Lock()
+	defer libraryScanMutex.Unlock()
+
+	result := &LibraryScanResult{}
+	seen := map[string]bool{}
+
+	for _, root := range utils.OutputRootDirs() {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// hidden directories hold scyd's own data (trash, caches...)
+			if d.IsDir() && path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			// nested output roots are walked on their own
+			if !d.Type().IsRegular() || seen[path] {
+				return nil
+			}
+
+			seen[path] = true
+
+			indexed, err := ls.indexFile(path)
+			if err != nil {
+				log.Printf("Failed to index %s: %v", path, err)
+				return nil
+			}
+
+			if indexed {
+				result.Indexed++
+			} else {
+				result.Unchanged++
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tracks []models.Track
+	if err := utils.DB.Select("id", "path").Find(&tracks).Error; err != nil {
+		return nil, err
+	}
+
+	for _, track := range tracks {
+		if seen[track.Path] {
+			continue
+		}
+
+		if err := utils.DB.Delete(&models.Track{}, track.ID).Error; err != nil {
+			return nil, err
+		}
+		result.Removed++
+	}
+
+	return result, ls.removeOrphans()
+}
+
+// IndexFiles updates the index for the given paths, files that no longer exist are removed
+// non audio files are ignored
+func (ls *LibraryService) IndexFiles(paths []string) error {
+	libraryScanMutex.Lock()
+	defer libraryScanMutex.Unlock()
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := utils.DB.Where("path = ?", path).Delete(&models.Track{}).Error; err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := ls.indexFile(path); err != nil {
+			log.Printf("Failed to index %s: %v", path, err)
+		}
+	}
+
+	return ls.removeOrphans()
+}
+
+// reads the tags of a file and upserts its track
+// returns false when the file did not change since it was last indexed
+func (ls *LibraryService) indexFile(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	var track models.Track
+	err = utils.DB.Where("path = ?", path).First(&track).Error
+
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return false, err
+	}
+
+	if err == nil && track.Size == info.Size() && track.ModTime.Equal(info.ModTime()) && track.IndexVersion == trackIndexVersion {
+		return false, nil
+	}
+
+	metadata, err := utils.GetMetadataFromFile(path)
+	if err != nil {
+		// not an audio file, make sure it is not indexed
+		if track.ID != 0 {
+			return false, utils.DB.Delete(&track).Error
+		}
+		return false, nil
+	}
+
+	artistName := metadata.Artist()
+	if artistName == "" {
+		artistName = UnknownArtistName
+	}
+
+	albumArtistName := metadata.AlbumArtist()
+	if albumArtistName == "" {
+		albumArtistName = artistName
+	}
+
+	artist, err := ls.findOrCreateArtist(artistName)
+	if err != nil {
+		return false, err
+	}
+
+	track.AlbumID = nil
+	if metadata.Album() != "" {
+		albumArtist, err := ls.findOrCreateArtist(albumArtistName)
+		if err != nil {
+			return false, err
+		}
+
+		album := models.Album{Title: metadata.Album(), ArtistID: albumArtist.ID}
+		err = utils.DB.Where(album).Attrs(models.Album{Year: metadata.Year()}).FirstOrCreate(&album).Error
+		if err != nil {
+			return false, err
+		}
+
+		track.AlbumID = &album.ID
+	}
+
+	trackNumber, _ := metadata.Track()
+	discNumber, _ := metadata.Disc()
+
+	track.Path = path
+	track.Title = metadata.Title()
+	track.ArtistID = artist.ID
+	track.TrackNumber = trackNumber
+	track.DiscNumber = discNumber
+	track.Year = metadata.Year()
+	track.Genre = metadata.Genre()
+	track.Format = string(metadata.FileType())
+	track.HasCover = metadata.Picture() != nil
+
+	properties, err := utils.GetAudioProperties(path)
+	if err != nil && !errors.Is(err, utils.ErrFfprobeNotFound) {
+		log.Printf("Failed to read audio properties of %s: %v", path, err)
+	}
+	if properties == nil {
+		properties = &utils.AudioProperties{}
+	}
+	track.Duration = properties.Duration
+	track.Bitrate = properties.Bitrate
+	track.SampleRate = properties.SampleRate
+	track.Codec = properties.Codec
+	track.IndexVersion = trackIndexVersion
+
+	// yt-dlp stores the webpage url in the comment when embedding metadata
+	if comment := strings.TrimSpace(metadata.Comment()); strings.HasPrefix(comment, "http://") || strings.HasPrefix(comment, "https://") {
+		track.SourceURL = comment
+	}
+	track.Size = info.Size()
+	track.ModTime = info.ModTime()
+
+	track.Untitled = track.Title == ""
+	if track.Untitled {
+		track.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	// Save inserts new tracks and updates every column of existing ones (including a nil album)
+	return true, utils.DB.Omit("Artist", "Album").Save(&track).Error
+}
+
+// MoveTrack points the track of oldPath to newPath and reindexes it, keeping its ID
+func (ls *LibraryService) MoveTrack(oldPath, newPath string) error {
+	err := utils.DB.Model(&models.Track{}).Where("path = ?", oldPath).Update("path", newPath).Error
+	if err != nil {
+		return err
+	}
+
+	return ls.IndexFiles([]string{newPath})
+}
+
+// SetTrackProvenance records where the track at path was downloaded from
+func (ls *LibraryService) SetTrackProvenance(path string, extractor string, sourceID string) error {
+	result := utils.DB.Model(&models.Track{}).Where("path = ?", path).Updates(map[string]interface{}{
+		"extractor": extractor,
+		"source_id": sourceID,
+	})
+
+	return result.Error
+}
+
+// GetTracksBySource returns the indexed tracks downloaded from the given extractor, keyed by source ID
+func (ls *LibraryService) GetTracksBySource(extractor string) (map[string]models.Track, error) {
+	var tracks []models.Track
+	err := utils.DB.Preload("Artist").Where("extractor = ?", extractor).Find(&tracks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	bySourceID := map[string]models.Track{}
+	for _, track := range tracks {
+		bySourceID[track.SourceID] = track
+	}
+
+	return bySourceID, nil
+}
+
+func (ls *LibraryService) findOrCreateArtist(name string) (*models.Artist, error) {
+	artist := models.Artist{Name: name}
+	err := utils.DB.Where(artist).FirstOrCreate(&artist).Error
+	if err != nil {
+		return nil, err
+	}
+	return &artist, nil
+}
+
+// removes albums without tracks, then artists without tracks or albums
+func (ls *LibraryService) removeOrphans() error {
+	err := utils.DB.
+		Where("id NOT IN (?)", utils.DB.Model(&models.Track{}).Distinct("album_id").Where("album_id IS NOT NULL")).
+		Delete(&models.Album{}).Error
+	if err != nil {
+		return err
+	}
+
+	return utils.DB.
+		Where("id NOT IN (?)", utils.DB.Model(&models.Track{}).Distinct("artist_id")).
+		Where("id NOT IN (?)", utils.DB.Model(&models.Album{}).Distinct("artist_id")).
+		Delete(&models.Artist{}).Error
+}
+
+type Pagination struct {
+	Page     int
+	PageSize int
+}
+
+func (p Pagination) apply(db *gorm.DB) *gorm.DB {
+	return db.Offset((p.Page - 1) * p.PageSize).Limit(p.PageSize)
+}
+
+type TrackFilter struct {
+	// case insensitive partial match on the title
+	Query    string
+	ArtistID uint
+	AlbumID  uint
+	// case insensitive partial match on the artist name
+	Artist string
+	// case insensitive partial match on the album title
+	Album string
+	Genre string
+	Year  int
+}
+
+func (ls *LibraryService) GetTracks(filter TrackFilter, pagination Pagination) ([]models.Track, int64, error) {
+	query := utils.DB.Model(&models.Track{})
+
+	if filter.Query != "" {
+		query = query.Where("tracks.title LIKE ?", "%"+filter.Query+"%")
+	}
+	if filter.ArtistID != 0 {
+		query = query.Where("tracks.artist_id = ?", filter.ArtistID)
+	}
+	if filter.AlbumID != 0 {
+		query = query.Where("tracks.album_id = ?", filter.AlbumID)
+	}
+	if filter.Artist != "" {
+		query = query.Where("tracks.artist_id IN (?)", utils.DB.Model(&models.Artist{}).Select("id").Where("name LIKE ?", "%"+filter.Artist+"%"))
+	}
+	if filter.Album != "" {
+		query = query.Where("tracks.album_id IN (?)", utils.DB.Model(&models.Album{}).Select("id").Where("title LIKE ?", "%"+filter.Album+"%"))
+	}
+	if filter.Genre != "" {
+		query = query.Where("tracks.genre LIKE ?", filter.Genre)
+	}
+	if filter.Year != 0 {
+		query = query.Where("tracks.year = ?", filter.Year)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var tracks []models.Track
+	err := pagination.apply(query).
+		Preload("Artist").
+		Preload("Album").
+		Order("tracks.path ASC").
+		Find(&tracks).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return tracks, total, nil
+}
+
+func (ls *LibraryService) GetTrack(id uint) (*models.Track, error) {
+	var track models.Track
+	result := utils.DB.Preload("Artist").Preload("Album").First(&track, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &track, nil
+}
+
+func (ls *LibraryService) GetTrackByPath(path string) (*models.Track, error) {
+	var track models.Track
+	result := utils.DB.Preload("Artist").Preload("Album").Where("path = ?", path).First(&track)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &track, nil
+}
+
+type AlbumFilter struct {
+	// case insensitive partial match on the title
+	Query    string
+	ArtistID uint
+	// case insensitive partial match on the album artist name
+	Artist string
+	// albums with at least one track of this genre
+	Genre string
+	Year  int
+}
+
+func (ls *LibraryService) GetAlbums(filter AlbumFilter, pagination Pagination) ([]models.Album, int64, error) {
+	query := utils.DB.Model(&models.Album{})
+
+	if filter.Query != "" {
+		query = query.Where("albums.title LIKE ?", "%"+filter.Query+"%")
+	}
+	if filter.ArtistID != 0 {
+		query = query.Where("albums.artist_id = ?", filter.ArtistID)
+	}
+	if filter.Artist != "" {
+		query = query.Where("albums.artist_id IN (?)", utils.DB.Model(&models.Artist{}).Select("id").Where("name LIKE ?", "%"+filter.Artist+"%"))
+	}
+	if filter.Genre != "" {
+		query = query.Where("albums.id IN (?)", utils.DB.Model(&models.Track{}).Select("album_id").Where("genre LIKE ?", filter.Genre))
+	}
+	if filter.Year != 0 {
+		query = query.Where("albums.year = ?", filter.Year)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var albums []models.Album
+	err := pagination.apply(query).Preload("Artist").Order("albums.title ASC").Find(&albums).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return albums, total, nil
+}
+
+func (ls *LibraryService) GetArtists(query string, pagination Pagination) ([]models.Artist, int64, error) {
+	db := utils.DB.Model(&models.Artist{})
+
+	if query != "" {
+		db = db.Where("name LIKE ?", "%"+query+"%")
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var artists []models.Artist
+	err := pagination.apply(db).Order("name ASC").Find(&artists).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return artists, total, nil
+}
+
+type ArtistStorage struct {
+	ArtistID   uint   `json:"artist_id"`
+	Name       string `json:"name"`
+	TrackCount int64  `json:"track_count"`
+	// sum of the size of the artist's tracks, in bytes
+	Size int64 `json:"size"`
+}
+
+// GetArtistStorage returns the space used by the tracks of each artist, largest first
+func (ls *LibraryService) GetArtistStorage() ([]ArtistStorage, error) {
+	artists := []ArtistStorage{}
+
+	err := utils.DB.Model(&models.Track{}).
+		Select("artists.id AS artist_id, artists.name AS name, COUNT(tracks.id) AS track_count, COALESCE(SUM(tracks.size), 0) AS size").
+		Joins("JOIN artists ON artists.id = tracks.artist_id").
+		Group("artists.id, artists.name").
+		Order("size DESC, artists.name ASC").
+		Scan(&artists).Error
+
+	return artists, err
+}
+
+func (ls *LibraryService) GetAlbum(id uint) (*models.Album, error) {
+	var album models.Album
+	result := utils.DB.Preload("Artist").First(&album, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &album, nil
+}
+
+// GetAlbumTracks returns the tracks of an album, in disc and track order
+func (ls *LibraryService) GetAlbumTracks(albumID uint) ([]models.Track, error) {
+	var tracks []models.Track
+	err := utils.DB.Preload("Artist").Preload("Album").
+		Where("album_id = ?", albumID).
+		Order("disc_number ASC, track_number ASC, path ASC").
+		Find(&tracks).Error
+
+	return tracks, err
+}
+
+// GetArtistTracks returns the tracks of an artist and of the albums they are the album artist of
+func (ls *LibraryService) GetArtistTracks(artistID uint) ([]models.Track, error) {
+	var tracks []models.Track
+	err := utils.DB.Preload("Artist").Preload("Album").
+		Where("artist_id = ? OR album_id IN (?)", artistID, utils.DB.Model(&models.Album{}).Select("id").Where("artist_id = ?", artistID)).
+		Order("path ASC").
+		Find(&tracks).Error
+
+	return tracks, err
+}
+
+func (ls *LibraryService) GetTracksByIDs(ids []uint) ([]models.Track, error) {
+	var tracks []models.Track
+	err := utils.DB.Preload("Artist").Preload("Album").Where("id IN ?", ids).Order("path ASC").Find(&tracks).Error
+
+	return tracks, err
+}
+
+func (ls *LibraryService) GetArtist(id uint) (*models.Artist, error) {
+	var artist models.Artist
+	result := utils.DB.First(&artist, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &artist, nil
+}
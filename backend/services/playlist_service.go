@@ -0,0 +1,66 @@
+package services
+
+import (
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+type PlaylistService struct{}
+
+func NewPlaylistService() *PlaylistService {
+	return &PlaylistService{}
+}
+
+// SyncPlaylist creates or renames the playlist and stores the given entries at their positions
+// entries of the playlist that were not downloaded again are kept, a track is only kept at its latest position
+func (ps *PlaylistService) SyncPlaylist(extractor string, sourceID string, title string, entries []models.PlaylistEntry) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		playlist := models.Playlist{Extractor: extractor, SourceID: sourceID}
+		if err := tx.Where(playlist).FirstOrCreate(&playlist).Error; err != nil {
+			return err
+		}
+
+		if title != "" && title != playlist.Title {
+			if err := tx.Model(&playlist).Update("title", title).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range entries {
+			err := tx.
+				Where("playlist_id = ?", playlist.ID).
+				Where("position = ? OR (extractor = ? AND source_id = ?)", entry.Position, entry.Extractor, entry.SourceID).
+				Delete(&models.PlaylistEntry{}).Error
+			if err != nil {
+				return err
+			}
+
+			entry.ID = 0
+			entry.PlaylistID = playlist.ID
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// returns every playlist with its entries in playlist order
+func (ps *PlaylistService) GetAllPlaylists() ([]models.Playlist, error) {
+	var playlists []models.Playlist
+	result := utils.DB.
+		Preload("Entries", func(db *gorm.DB) *gorm.DB { return db.Order("position ASC") }).
+		Order("id ASC").
+		Find(&playlists)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return playlists, nil
+}
+
+func (ps *PlaylistService) SetPlaylistFileName(id uint, fileName string) error {
+	return utils.DB.Model(&models.Playlist{}).Where("id = ?", id).Update("file_name", fileName).Error
+}
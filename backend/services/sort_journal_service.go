@@ -0,0 +1,79 @@
+package services
+
+import (
+	"time"
+
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+type SortJournalService struct{}
+
+func NewSortJournalService() *SortJournalService {
+	return &SortJournalService{}
+}
+
+func (sjs *SortJournalService) CreateRun() (*models.SortRun, error) {
+	run := &models.SortRun{
+		State: models.SortRunStateCompleted,
+	}
+
+	result := utils.DB.Create(run)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return run, nil
+}
+
+func (sjs *SortJournalService) RecordMove(move *models.SortMove) error {
+	return utils.DB.Create(move).Error
+}
+
+func (sjs *SortJournalService) RecordCreatedDirs(runID uint, dirs []string) error {
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	runDirs := make([]models.SortRunDir, 0, len(dirs))
+	for _, dir := range dirs {
+		runDirs = append(runDirs, models.SortRunDir{RunID: runID, Path: dir})
+	}
+
+	return utils.DB.Create(&runDirs).Error
+}
+
+func (sjs *SortJournalService) GetAllRuns() ([]models.SortRun, error) {
+	var runs []models.SortRun
+	result := utils.DB.Order("created_at DESC").Find(&runs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return runs, nil
+}
+
+// returns the run with its moves and created directories
+func (sjs *SortJournalService) GetRun(id uint) (*models.SortRun, error) {
+	var run models.SortRun
+	result := utils.DB.
+		Preload("Moves", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Preload("CreatedDirs", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		First(&run, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &run, nil
+}
+
+func (sjs *SortJournalService) MarkMoveUndone(id uint) error {
+	result := utils.DB.Model(&models.SortMove{}).Where("id = ?", id).Update("undone_at", time.Now())
+	return result.Error
+}
+
+func (sjs *SortJournalService) UpdateRunState(id uint, state models.SortRunState) error {
+	result := utils.DB.Model(&models.SortRun{}).Where("id = ?", id).Update("state", state)
+	return result.Error
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+)
+
+type TagMatchService struct{}
+
+func NewTagMatchService() *TagMatchService {
+	return &TagMatchService{}
+}
+
+func (tms *TagMatchService) CreateMatch(match *models.TagMatch) error {
+	return utils.DB.Create(match).Error
+}
+
+// HasMatch reports whether the file at path was already looked up
+func (tms *TagMatchService) HasMatch(path string) bool {
+	var count int64
+	utils.DB.Model(&models.TagMatch{}).Where("path = ?", path).Count(&count)
+	return count > 0
+}
+
+// UpdatePath points the matches of a file to its new path after it was moved
+func (tms *TagMatchService) UpdatePath(oldPath, newPath string) error {
+	return utils.DB.Model(&models.TagMatch{}).Where("path = ?", oldPath).Update("path", newPath).Error
+}
+
+// returns the matches in the given state, newest first, every match when state is empty
+func (tms *TagMatchService) GetMatches(state models.TagMatchState) ([]models.TagMatch, error) {
+	query := utils.DB.Order("created_at DESC")
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+
+	var matches []models.TagMatch
+	if err := query.Find(&matches).Error; err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+func (tms *TagMatchService) GetMatch(id uint) (*models.TagMatch, error) {
+	var match models.TagMatch
+	if err := utils.DB.First(&match, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &match, nil
+}
+
+func (tms *TagMatchService) UpdateMatch(id uint, state models.TagMatchState, path string) error {
+	return utils.DB.Model(&models.TagMatch{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"state": state,
+		"path":  path,
+	}).Error
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"time"
+
+	"github.com/nicolassutter/scyd/models"
+	"github.com/nicolassutter/scyd/utils"
+	"gorm.io/gorm"
+)
+
+type TrashService struct{}
+
+func NewTrashService() *TrashService {
+	return &TrashService{}
+}
+
+func (ts *TrashService) CreateEntry(entry *models.TrashEntry) error {
+	return utils.DB.Create(entry).Error
+}
+
+func (ts *TrashService) RecordFile(file *models.TrashFile) error {
+	return utils.DB.Create(file).Error
+}
+
+// returns every entry with its files, most recently deleted first
+func (ts *TrashService) GetAllEntries() ([]models.TrashEntry, error) {
+	var entries []models.TrashEntry
+	result := utils.DB.
+		Preload("Files", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Order("created_at DESC").
+		Find(&entries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return entries, nil
+}
+
+func (ts *TrashService) GetEntry(id uint) (*models.TrashEntry, error) {
+	var entry models.TrashEntry
+	result := utils.DB.
+		Preload("Files", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		First(&entry, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &entry, nil
+}
+
+// returns the entries deleted before the given time
+func (ts *TrashService) GetEntriesBefore(before time.Time) ([]models.TrashEntry, error) {
+	var entries []models.TrashEntry
+	result := utils.DB.Where("created_at < ?", before).Order("created_at ASC").Find(&entries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return entries, nil
+}
+
+func (ts *TrashService) DeleteFile(id uint) error {
+	return utils.DB.Delete(&models.TrashFile{}, id).Error
+}
+
+// removes the entry and its files from the database
+func (ts *TrashService) DeleteEntry(id uint) error {
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("entry_id = ?", id).Delete(&models.TrashFile{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&models.TrashEntry{}, id).Error
+	})
+}
@@ -5,12 +5,15 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 
 	"gopkg.in/yaml.v3"
 )
 
 type User struct {
 	PasswordHash string `yaml:"password_hash"`
+	// admin, user or viewer, admin when not set
+	Role Role `yaml:"role"`
 }
 
 // hook_name: command
@@ -19,12 +22,147 @@ type Hooks struct {
 	OnDownloadComplete string `yaml:"on_download_complete"`
 }
 
+type CoverConfig struct {
+	// Write a cover image into album folders that don't have one
+	Enabled bool `yaml:"enabled"`
+	// Name of the cover file without extension, "cover" or "folder"
+	FileName string `yaml:"file_name"`
+	// Covers larger than this many pixels (width or height) are shrunk, 0 keeps the original size
+	MaxSize int `yaml:"max_size"`
+	// Embed the album cover into tracks that don't have one
+	EmbedMissing bool `yaml:"embed_missing"`
+}
+
+type QuarantineConfig struct {
+	// Move files the sort can't place out of the download dir
+	Enabled bool `yaml:"enabled"`
+	// Defaults to .quarantine inside the download dir
+	Dir string `yaml:"dir"`
+	// Files modified more recently than this are left alone, a download might still be writing them
+	MinAgeMinutes int `yaml:"min_age_minutes"`
+}
+
+type WatcherConfig struct {
+	// Sort files copied into the download dir by hand
+	Enabled bool `yaml:"enabled"`
+	// Files are sorted once nothing changed in the download dir for this long
+	DebounceSeconds int `yaml:"debounce_seconds"`
+}
+
+type MediaServerConfig struct {
+	// jellyfin or plex
+	Type   string `yaml:"type"`
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+	// Library (Jellyfin) or section (Plex) refreshed when too many directories changed, required for Plex
+	LibraryID string `yaml:"library_id"`
+	// Output root the library of the server points to, the output dir by default
+	OutputRoot string `yaml:"output_root"`
+	// Path of the output root as seen by the media server, when it differs (another container for instance)
+	OutputDir string `yaml:"output_dir"`
+}
+
+type EnrichmentConfig struct {
+	// Look up downloads on MusicBrainz and apply their canonical tags before sorting
+	Enabled bool `yaml:"enabled"`
+	// Base URL of the MusicBrainz API, can point to a mirror
+	MusicBrainzURL string `yaml:"musicbrainz_url"`
+	// Fingerprints files with fpcalc when set
+	AcoustIDKey string `yaml:"acoustid_key"`
+	AcoustIDURL string `yaml:"acoustid_url"`
+	// Matches with a lower confidence (0 to 100) wait in the review queue instead of being applied
+	MinConfidence int `yaml:"min_confidence"`
+}
+
+type TranscodingConfig struct {
+	// Where transcoded tracks are kept
+	CacheDir string `yaml:"cache_dir"`
+	// The least recently played files are removed above this size, in megabytes
+	CacheMaxSizeMB int64 `yaml:"cache_max_size_mb"`
+}
+
+type DiskSpaceConfig struct {
+	// Downloads don't start when the download dir has less free space than this, in megabytes, 0 disables the check
+	MinFreeDownloadMB int64 `yaml:"min_free_download_mb"`
+	// Files are not copied into the output dir when it would leave less free space than this, in megabytes, 0 disables the check
+	MinFreeOutputMB int64 `yaml:"min_free_output_mb"`
+}
+
+type RetentionConfig struct {
+	// Successful downloads are removed from the history after this many days, 0 (the default) keeps them forever
+	SuccessfulDownloadsDays int `yaml:"successful_downloads_days"`
+	// Partial and temporary files of the download dir untouched for this many hours are removed, 0 keeps them
+	TempFilesHours int `yaml:"temp_files_hours"`
+	// How often the maintenance runs, 0 only runs it when triggered through the API
+	IntervalHours int `yaml:"interval_hours"`
+}
+
+type TrashConfig struct {
+	// Deleted tracks and albums are removed for good after this many days, 0 keeps them until purged by hand
+	RetentionDays int `yaml:"retention_days"`
+}
+
+type OutputRootConfig struct {
+	// Referenced by the routing rules, "default" is the output dir
+	Name string `yaml:"name"`
+	Dir  string `yaml:"dir"`
+	// Path of sorted files inside Dir, without extension, defaults to "{artist}/{album}/{file_name}"
+	PathTemplate string `yaml:"path_template"`
+}
+
+type RoutingRuleConfig struct {
+	Name string `yaml:"name"`
+	// Every condition that is set must match, a rule without conditions matches every file
+	// Extractors of the download (youtube, soundcloud, mixcloud...), case insensitive
+	Extractors []string `yaml:"extractors"`
+	// Genre tags, case insensitive
+	Genres []string `yaml:"genres"`
+	// Uploaders from the .info.json sidecar, or artist tags when there is none, case insensitive
+	Uploaders          []string `yaml:"uploaders"`
+	MinDurationSeconds float64  `yaml:"min_duration_seconds"`
+	MaxDurationSeconds float64  `yaml:"max_duration_seconds"`
+	TitleRegex         string   `yaml:"title_regex"`
+	// Name of the output root receiving the matching files
+	Output string `yaml:"output"`
+
+	titleRegex *regexp.Regexp
+}
+
 type config struct {
 	DownloadDir string `yaml:"download_dir"`
 	OutputDir   string `yaml:"output_dir"`
 	PublicDir   string `yaml:"public_dir"`
 	// Automatically sort downloads after each download completes
 	SortAfterDownload bool `yaml:"sort_after_download"`
+	// How sorted files are placed in the output dir: move, hardlink or reflink
+	MoveMode MoveMode `yaml:"move_mode"`
+	// Name sorted files from their tags ("01 - Title.mp3") instead of keeping the download file name
+	RenameFiles bool `yaml:"rename_files"`
+	// Album cover art management during sorting
+	Covers CoverConfig `yaml:"covers"`
+	// Leftover files of the download dir
+	Quarantine QuarantineConfig `yaml:"quarantine"`
+	// Watches the download dir for new files
+	Watcher WatcherConfig `yaml:"watcher"`
+	// Refreshed after the output dir changed
+	MediaServers []MediaServerConfig `yaml:"media_servers"`
+	// Additional roots that sorted files can be routed to
+	OutputRoots []OutputRootConfig `yaml:"output_roots"`
+	// Ordered rules choosing the output root of each sorted file, the first matching rule wins
+	// files no rule matches go to the output dir
+	Routing []RoutingRuleConfig `yaml:"routing"`
+	// Where the m3u8 files of downloaded playlists are written, defaults to the output dir
+	PlaylistsDir string `yaml:"playlists_dir"`
+	// MusicBrainz tagging of downloads
+	Enrichment EnrichmentConfig `yaml:"enrichment"`
+	// Transcoded streams of library tracks
+	Transcoding TranscodingConfig `yaml:"transcoding"`
+	// Free space kept on the download and output dirs
+	DiskSpace DiskSpaceConfig `yaml:"disk_space"`
+	// Cleanup of the download history and of download leftovers
+	Retention RetentionConfig `yaml:"retention"`
+	// Tracks and albums deleted through scyd, kept in .trash inside the output dir
+	Trash TrashConfig `yaml:"trash"`
 	// Users for authentication
 	Users map[string]User `yaml:"users"`
 	Hooks Hooks           `yaml:"hooks"`
@@ -50,9 +188,38 @@ func newConfig() *config {
 		DownloadDir:       "/downloads",
 		OutputDir:         "/output",
 		SortAfterDownload: true,
-		Users:             make(map[string]User),
-		Hooks:             Hooks{},
-		PublicDir:         "/public",
+		MoveMode:          MoveModeMove,
+		RenameFiles:       true,
+		Covers: CoverConfig{
+			Enabled:  true,
+			FileName: "cover",
+		},
+		Quarantine: QuarantineConfig{
+			Enabled:       true,
+			MinAgeMinutes: 60,
+		},
+		Watcher: WatcherConfig{
+			DebounceSeconds: 30,
+		},
+		Enrichment: EnrichmentConfig{
+			MusicBrainzURL: "https://musicbrainz.org/ws/2",
+			AcoustIDURL:    "https://api.acoustid.org/v2",
+			MinConfidence:  90,
+		},
+		Transcoding: TranscodingConfig{
+			CacheDir:       "./config/cache/transcodes",
+			CacheMaxSizeMB: 2048,
+		},
+		Retention: RetentionConfig{
+			TempFilesHours: 24,
+			IntervalHours:  24,
+		},
+		Trash: TrashConfig{
+			RetentionDays: 30,
+		},
+		Users:     make(map[string]User),
+		Hooks:     Hooks{},
+		PublicDir: "/public",
 	}
 	return config
 }
@@ -93,5 +260,19 @@ func ReadUserConfigFile() (*config, error) {
 		log.Fatalf("Error creating %s dir: %s", err, UserConfig.OutputDir)
 	}
 
+	if err := validateUsers(); err != nil {
+		log.Fatalf("Invalid users: %s", err)
+	}
+
+	if err := validateOutputRoots(); err != nil {
+		log.Fatalf("Invalid output roots: %s", err)
+	}
+
+	for _, root := range UserConfig.OutputRoots {
+		if err := os.MkdirAll(root.Dir, os.ModePerm); err != nil {
+			log.Fatalf("Error creating %s dir: %s", root.Dir, err)
+		}
+	}
+
 	return UserConfig, nil
 }
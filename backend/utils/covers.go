@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// names and extensions of the cover images media servers pick up in album folders
+var (
+	coverBaseNames  = []string{"cover", "folder"}
+	coverExtensions = []string{".jpg", ".jpeg", ".png"}
+)
+
+// IsCoverFileName reports whether name is an album cover image name like cover.jpg, folder.png
+// or the configured covers.file_name
+func IsCoverFileName(name string) bool {
+	ext := filepath.Ext(name)
+	if !slices.Contains(coverExtensions, strings.ToLower(ext)) {
+		return false
+	}
+
+	baseName := strings.TrimSuffix(name, ext)
+	if UserConfig.Covers.FileName != "" && strings.EqualFold(baseName, UserConfig.Covers.FileName) {
+		return true
+	}
+
+	return slices.ContainsFunc(coverBaseNames, func(coverName string) bool {
+		return strings.EqualFold(baseName, coverName)
+	})
+}
+
+// FindCoverFile returns the path of the cover image of a directory, or an empty string
+func FindCoverFile(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && IsCoverFileName(entry.Name()) {
+			return filepath.Join(dir, entry.Name())
+		}
+	}
+
+	return ""
+}
+
+// WriteCoverFile writes the image as the cover of dir, resized according to the config
+// returns the path of the written file
+func WriteCoverFile(dir string, data []byte) (string, error) {
+	ext := ""
+	// webp, the default format of yt-dlp thumbnails, is converted to jpeg, not every media server reads it
+	convert := false
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/png":
+		ext = ".png"
+	case "image/webp":
+		ext, convert = ".webp", true
+	default:
+		return "", ErrInvalidCover
+	}
+
+	fileName := UserConfig.Covers.FileName
+	if fileName == "" {
+		fileName = "cover"
+	}
+
+	tmpFile, err := os.CreateTemp(dir, "."+fileName+".*"+ext)
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if maxSize := UserConfig.Covers.MaxSize; maxSize > 0 || convert {
+		resizedPath := filepath.Join(dir, "."+fileName+".resized.jpg")
+		defer os.Remove(resizedPath)
+
+		args := []string{"-i", tmpPath}
+		if maxSize > 0 {
+			// only shrinks, smaller images keep their size
+			args = append(args, "-vf", fmt.Sprintf("scale='min(%d,iw)':'min(%d,ih)':force_original_aspect_ratio=decrease", maxSize, maxSize))
+		}
+		args = append(args, "-frames:v", "1", "-q:v", "2", resizedPath)
+
+		err := RunFfmpeg(context.Background(), args...)
+
+		switch {
+		case err == nil:
+			tmpPath, ext = resizedPath, ".jpg"
+		case convert:
+			return "", fmt.Errorf("failed to convert webp cover: %w", err)
+		default:
+			// a full size cover is better than none
+			log.Printf("Failed to resize cover for %s, keeping the original size: %v", dir, err)
+		}
+	}
+
+	coverPath := filepath.Join(dir, fileName+ext)
+
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmpPath, coverPath); err != nil {
+		return "", err
+	}
+
+	return coverPath, nil
+}
@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestIsCoverFileName(t *testing.T) {
+	previousFileName := UserConfig.Covers.FileName
+	t.Cleanup(func() { UserConfig.Covers.FileName = previousFileName })
+	UserConfig.Covers.FileName = "albumart"
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"cover.jpg", true},
+		{"Folder.PNG", true},
+		{"cover.jpeg", true},
+		{"albumart.jpg", true},
+		{"AlbumArt.png", true},
+		{"albumart.txt", false},
+		{"cover.webp", false},
+		{"song.jpg", false},
+		{"cover", false},
+	}
+
+	for _, test := range tests {
+		if got := IsCoverFileName(test.name); got != test.want {
+			t.Errorf("IsCoverFileName(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
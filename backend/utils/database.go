@@ -22,7 +22,21 @@ func InitDatabase() error {
 	}
 
 	// Auto migrate the schema
-	err = DB.AutoMigrate(&models.Download{})
+	err = DB.AutoMigrate(
+		&models.Download{},
+		&models.SortRun{},
+		&models.SortMove{},
+		&models.SortRunDir{},
+		&models.Artist{},
+		&models.Album{},
+		&models.Track{},
+		&models.Playlist{},
+		&models.PlaylistEntry{},
+		&models.TagMatch{},
+		&models.TrashEntry{},
+		&models.TrashFile{},
+		&models.APIToken{},
+	)
 	if err != nil {
 		log.Printf("Failed to migrate database: %v", err)
 		return err
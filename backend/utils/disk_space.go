@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInsufficientSpace = errors.New("not enough free disk space")
+
+// in bytes
+type DiskUsage struct {
+	Total uint64
+	Free  uint64
+	Used  uint64
+}
+
+// MinFreeSpace returns the free space to keep on the filesystem of path, in bytes
+// the download dir has its own threshold, every other directory uses the output dir one
+func MinFreeSpace(path string) uint64 {
+	minFreeMB := UserConfig.DiskSpace.MinFreeOutputMB
+
+	relativePath, err := filepath.Rel(UserConfig.DownloadDir, path)
+	if err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		minFreeMB = UserConfig.DiskSpace.MinFreeDownloadMB
+	}
+
+	return uint64(max(minFreeMB, 0)) * 1024 * 1024
+}
+
+// EnsureFreeSpace returns ErrInsufficientSpace when writing size bytes into dir would leave
+// less than its minimum free space
+// platforms without disk usage support are never refused
+func EnsureFreeSpace(dir string, size int64) error {
+	minFree := MinFreeSpace(dir)
+	if minFree == 0 && size <= 0 {
+		return nil
+	}
+
+	usage, err := GetDiskUsage(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	needed := minFree + uint64(max(size, 0))
+	if usage.Free < needed {
+		return fmt.Errorf("%w in %s: %d MB free, %d MB needed", ErrInsufficientSpace, dir, usage.Free/1024/1024, needed/1024/1024)
+	}
+
+	return nil
+}
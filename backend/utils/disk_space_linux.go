@@ -0,0 +1,23 @@
+//go:build linux
+
+package utils
+
+import "golang.org/x/sys/unix"
+
+// GetDiskUsage returns the size and free space of the filesystem holding path
+func GetDiskUsage(path string) (*DiskUsage, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return nil, err
+	}
+
+	blockSize := uint64(stat.Bsize)
+	usage := &DiskUsage{
+		Total: stat.Blocks * blockSize,
+		// blocks reserved for root are not available to scyd
+		Free: stat.Bavail * blockSize,
+	}
+	usage.Used = usage.Total - stat.Bfree*blockSize
+
+	return usage, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package utils
+
+import "errors"
+
+func GetDiskUsage(path string) (*DiskUsage, error) {
+	return nil, errors.ErrUnsupported
+}
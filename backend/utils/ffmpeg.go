@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// RunFfmpeg runs ffmpeg quietly with the given args, stderr is returned in the error on failure
+func RunFfmpeg(ctx context.Context, args ...string) error {
+	baseArgs := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(baseArgs, args...)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// TranscodeFormat describes an output format of TranscodeAudio
+type TranscodeFormat struct {
+	Codec       string
+	Muxer       string
+	Extension   string
+	ContentType string
+}
+
+// formats tracks can be transcoded to, key: name used in the API
+var TranscodeFormats = map[string]TranscodeFormat{
+	"opus": {Codec: "libopus", Muxer: "ogg", Extension: ".opus", ContentType: "audio/ogg"},
+	"mp3":  {Codec: "libmp3lame", Muxer: "mp3", Extension: ".mp3", ContentType: "audio/mpeg"},
+	"aac":  {Codec: "aac", Muxer: "ipod", Extension: ".m4a", ContentType: "audio/mp4"},
+}
+
+// TranscodeAudio converts the audio stream of src to dst, bitrate is in kbit/s
+// tags are kept, cover art is dropped
+func TranscodeAudio(ctx context.Context, src string, dst string, format TranscodeFormat, bitrate int) error {
+	args := []string{
+		"-i", src,
+		"-map", "0:a:0",
+		"-map_metadata", "0",
+		"-vn",
+		"-c:a", format.Codec,
+		"-b:a", fmt.Sprintf("%dk", bitrate),
+	}
+
+	if format.Muxer == "ipod" {
+		// players can start before the whole file is downloaded
+		args = append(args, "-movflags", "+faststart")
+	}
+
+	args = append(args, "-f", format.Muxer, dst)
+
+	return RunFfmpeg(ctx, args...)
+}
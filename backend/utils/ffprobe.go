@@ -0,0 +1,328 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+var ErrFfprobeNotFound = errors.New("ffprobe is not installed")
+
+var ErrNoAudioStream = errors.New("no audio stream")
+
+// audio files worth running ffprobe on when the native parser fails, covers and sidecars are not
+var ffprobeExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".m4a":  true,
+	".mp4":  true,
+	".aac":  true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".wav":  true,
+	".webm": true,
+	".mka":  true,
+	".wma":  true,
+	".aif":  true,
+	".aiff": true,
+}
+
+// IsAudioFileName reports whether the file has the extension of an audio format the library reads
+func IsAudioFileName(name string) bool {
+	return ffprobeExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// AudioProperties describes the audio stream of a file, zero values are unknown
+type AudioProperties struct {
+	Duration   float64 `json:"duration" doc:"In seconds"`
+	Bitrate    int     `json:"bitrate" doc:"In bit/s"`
+	SampleRate int     `json:"sample_rate" doc:"In Hz"`
+	Codec      string  `json:"codec"`
+}
+
+type ffprobeStream struct {
+	Index       int               `json:"index"`
+	CodecType   string            `json:"codec_type"`
+	CodecName   string            `json:"codec_name"`
+	SampleRate  string            `json:"sample_rate"`
+	BitRate     string            `json:"bit_rate"`
+	Duration    string            `json:"duration"`
+	Tags        map[string]string `json:"tags"`
+	Disposition struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		BitRate    string            `json:"bit_rate"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+func probeFile(path string) (*ffprobeOutput, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return nil, ErrFfprobeNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_format", "-show_streams", "-of", "json", path)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, err
+	}
+
+	return &probe, nil
+}
+
+// the first audio stream, cover art is a video stream
+func (probe *ffprobeOutput) audioStream() *ffprobeStream {
+	for i, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			return &probe.Streams[i]
+		}
+	}
+	return nil
+}
+
+func (probe *ffprobeOutput) properties() AudioProperties {
+	properties := AudioProperties{}
+
+	properties.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	properties.Bitrate, _ = strconv.Atoi(probe.Format.BitRate)
+
+	stream := probe.audioStream()
+	if stream == nil {
+		return properties
+	}
+
+	properties.Codec = stream.CodecName
+	properties.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+
+	// the stream bitrate leaves out the cover art and the container overhead
+	if bitrate, err := strconv.Atoi(stream.BitRate); err == nil && bitrate > 0 {
+		properties.Bitrate = bitrate
+	}
+	if properties.Duration == 0 {
+		properties.Duration, _ = strconv.ParseFloat(stream.Duration, 64)
+	}
+
+	return properties
+}
+
+// GetAudioProperties returns the duration, bitrate, sample rate and codec of an audio file
+func GetAudioProperties(path string) (*AudioProperties, error) {
+	probe, err := probeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if probe.audioStream() == nil {
+		return nil, ErrNoAudioStream
+	}
+
+	properties := probe.properties()
+	return &properties, nil
+}
+
+// FfprobeMetadataReader reads the tags of the formats the native parser doesn't know (WebM, WAV, some M4A...)
+type FfprobeMetadataReader struct{}
+
+func (FfprobeMetadataReader) ReadMetadata(path string) (tag.Metadata, error) {
+	if !ffprobeExtensions[strings.ToLower(filepath.Ext(path))] {
+		return nil, tag.ErrNoTagsFound
+	}
+
+	probe, err := probeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if probe.audioStream() == nil {
+		return nil, ErrNoAudioStream
+	}
+
+	metadata := &ffprobeMetadata{path: path, probe: probe, tags: map[string]string{}}
+
+	// ogg and webm files keep their tags on the audio stream, other containers on the format
+	for _, tags := range []map[string]string{probe.audioStream().Tags, probe.Format.Tags} {
+		for key, value := range tags {
+			metadata.tags[strings.ToLower(key)] = value
+		}
+	}
+
+	return metadata, nil
+}
+
+// tag.Metadata of a file read by ffprobe
+type ffprobeMetadata struct {
+	path  string
+	probe *ffprobeOutput
+	// lowercase keys
+	tags map[string]string
+
+	pictureOnce sync.Once
+	picture     *tag.Picture
+}
+
+func (m *ffprobeMetadata) Format() tag.Format {
+	return tag.UnknownFormat
+}
+
+func (m *ffprobeMetadata) FileType() tag.FileType {
+	codec := m.probe.properties().Codec
+
+	switch {
+	case strings.Contains(m.probe.Format.FormatName, "mp3"):
+		return tag.MP3
+	case strings.Contains(m.probe.Format.FormatName, "flac"):
+		return tag.FLAC
+	case strings.Contains(m.probe.Format.FormatName, "ogg"):
+		return tag.OGG
+	case strings.Contains(m.probe.Format.FormatName, "mp4") && codec == "alac":
+		return tag.ALAC
+	case strings.Contains(m.probe.Format.FormatName, "mp4"):
+		return tag.M4A
+	case strings.Contains(m.probe.Format.FormatName, "webm"):
+		return tag.FileType("WEBM")
+	}
+
+	// "wav", "aiff"...
+	name, _, _ := strings.Cut(m.probe.Format.FormatName, ",")
+	return tag.FileType(strings.ToUpper(name))
+}
+
+func (m *ffprobeMetadata) tag(keys ...string) string {
+	for _, key := range keys {
+		if value := strings.TrimSpace(m.tags[key]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// "3/12" or "3"
+func (m *ffprobeMetadata) position(key string, totalKey string) (int, int) {
+	value, total, _ := strings.Cut(m.tag(key), "/")
+
+	number, _ := strconv.Atoi(strings.TrimSpace(value))
+	count, _ := strconv.Atoi(strings.TrimSpace(total))
+	if count == 0 {
+		count, _ = strconv.Atoi(m.tag(totalKey))
+	}
+
+	return number, count
+}
+
+func (m *ffprobeMetadata) Title() string {
+	return m.tag("title")
+}
+
+func (m *ffprobeMetadata) Album() string {
+	return m.tag("album")
+}
+
+func (m *ffprobeMetadata) Artist() string {
+	return m.tag("artist")
+}
+
+func (m *ffprobeMetadata) AlbumArtist() string {
+	return m.tag("album_artist", "albumartist", "album artist")
+}
+
+func (m *ffprobeMetadata) Composer() string {
+	return m.tag("composer")
+}
+
+func (m *ffprobeMetadata) Genre() string {
+	return m.tag("genre")
+}
+
+func (m *ffprobeMetadata) Lyrics() string {
+	return m.tag("lyrics", "unsyncedlyrics")
+}
+
+func (m *ffprobeMetadata) Comment() string {
+	return m.tag("comment", "description", "purl")
+}
+
+func (m *ffprobeMetadata) Year() int {
+	date := m.tag("date", "year", "originaldate")
+	if len(date) < 4 {
+		return 0
+	}
+
+	year, _ := strconv.Atoi(date[:4])
+	return year
+}
+
+func (m *ffprobeMetadata) Track() (int, int) {
+	return m.position("track", "tracktotal")
+}
+
+func (m *ffprobeMetadata) Disc() (int, int) {
+	return m.position("disc", "disctotal")
+}
+
+// the cover art stream is only extracted when asked for
+func (m *ffprobeMetadata) Picture() *tag.Picture {
+	m.pictureOnce.Do(func() {
+		for _, stream := range m.probe.Streams {
+			if stream.CodecType != "video" || stream.Disposition.AttachedPic != 1 {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			data, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin",
+				"-i", m.path, "-map", "0:"+strconv.Itoa(stream.Index), "-c", "copy", "-f", "image2pipe", "-").Output()
+			if err != nil || len(data) == 0 {
+				return
+			}
+
+			picture := &tag.Picture{Ext: "jpg", MIMEType: "image/jpeg", Type: "Cover (front)", Data: data}
+			if stream.CodecName == "png" {
+				picture.Ext, picture.MIMEType = "png", "image/png"
+			}
+
+			m.picture = picture
+			return
+		}
+	})
+
+	return m.picture
+}
+
+func (m *ffprobeMetadata) Raw() map[string]interface{} {
+	raw := make(map[string]interface{}, len(m.tags))
+	for key, value := range m.tags {
+		raw[key] = value
+	}
+	return raw
+}
@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+type MoveMode string
+
+const (
+	// rename the file, falls back to a copy when crossing filesystems
+	MoveModeMove MoveMode = "move"
+	// hardlink the file into place and leave the source untouched
+	MoveModeHardlink MoveMode = "hardlink"
+	// copy-on-write clone of the file, the source is left untouched
+	MoveModeReflink MoveMode = "reflink"
+)
+
+// KeepsSource reports whether the mode leaves the source file in place
+func (mode MoveMode) KeepsSource() bool {
+	return mode == MoveModeHardlink || mode == MoveModeReflink
+}
+
+// MoveFile places src at dst according to the given mode.
+// The destination parent directory must already exist.
+// Data is never left half-written at dst: cross-device copies are written to a temporary
+// file in the destination directory, synced to disk and then renamed into place.
+func MoveFile(src, dst string, mode MoveMode) error {
+	switch mode {
+	case MoveModeHardlink:
+		return os.Link(src, dst)
+	case MoveModeReflink:
+		return reflinkFile(src, dst)
+	case MoveModeMove, "":
+		// same filesystem: atomic and no data is copied
+		err := os.Rename(src, dst)
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+
+		if err := copyFileAtomic(src, dst); err != nil {
+			return err
+		}
+
+		// the copy is safely in place, a failure here only leaves a duplicate behind
+		if err := os.Remove(src); err != nil {
+			return fmt.Errorf("copied %s but failed to remove it: %w", src, err)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown move mode: %s", mode)
+	}
+}
+
+// copies src to a temporary file next to dst, syncs it and renames it into place
+// permissions and modification times are preserved
+func copyFileAtomic(src, dst string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	sourceInfo, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	// a full disk would leave a truncated copy
+	if err := EnsureFreeSpace(filepath.Dir(dst), sourceInfo.Size()); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	// remove the temporary file on every error path
+	success := false
+	defer func() {
+		if !success {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := io.Copy(tmpFile, sourceFile); err != nil {
+		return err
+	}
+
+	if err := tmpFile.Chmod(sourceInfo.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(tmpPath, sourceInfo.ModTime(), sourceInfo.ModTime()); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return err
+	}
+
+	success = true
+	syncDir(filepath.Dir(dst))
+
+	return nil
+}
+
+// flushes directory entries so a rename survives a crash, best effort
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
+
+// IsSameFile reports whether dst already holds src, either as a hardlink
+// or as a copy with identical size, modification time and content
+func IsSameFile(src, dst string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return false
+	}
+
+	if os.SameFile(srcInfo, dstInfo) {
+		return true
+	}
+
+	if srcInfo.Size() != dstInfo.Size() || !srcInfo.ModTime().Equal(dstInfo.ModTime()) {
+		return false
+	}
+
+	// a re-download or a re-encode can keep the size and the modification time
+	same, err := hasSameContent(src, dst)
+	return err == nil && same
+}
+
+func hasSameContent(src, dst string) (bool, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Open(dst)
+	if err != nil {
+		return false, err
+	}
+	defer dstFile.Close()
+
+	srcBuf := make([]byte, 64*1024)
+	dstBuf := make([]byte, 64*1024)
+
+	for {
+		srcRead, srcErr := io.ReadFull(srcFile, srcBuf)
+		dstRead, dstErr := io.ReadFull(dstFile, dstBuf)
+
+		if !bytes.Equal(srcBuf[:srcRead], dstBuf[:dstRead]) {
+			return false, nil
+		}
+
+		srcDone := srcErr == io.EOF || srcErr == io.ErrUnexpectedEOF
+		dstDone := dstErr == io.EOF || dstErr == io.ErrUnexpectedEOF
+
+		if srcDone || dstDone {
+			return srcDone && dstDone, nil
+		}
+		if srcErr != nil {
+			return false, srcErr
+		}
+		if dstErr != nil {
+			return false, dstErr
+		}
+	}
+}
+
+// MkdirAllTracked works like os.MkdirAll and returns the directories it had to create, top-down
+func MkdirAllTracked(dir string) ([]string, error) {
+	missing := []string{}
+
+	for current := filepath.Clean(dir); ; current = filepath.Dir(current) {
+		if _, err := os.Stat(current); err == nil {
+			break
+		}
+
+		missing = append([]string{current}, missing...)
+
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return missing, nil
+}
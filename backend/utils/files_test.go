@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsSameFile(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	src := write("src.mp3", "original audio")
+	link := filepath.Join(dir, "link.mp3")
+	if err := os.Link(src, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dst  string
+		want bool
+	}{
+		{"hardlink", link, true},
+		{"identical copy", write("copy.mp3", "original audio"), true},
+		{"same size and time, other content", write("reencode.mp3", "modified audio"), false},
+		{"other size", write("longer.mp3", "original audio, longer"), false},
+		{"missing", filepath.Join(dir, "missing.mp3"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsSameFile(src, test.dst); got != test.want {
+				t.Errorf("IsSameFile(%q, %q) = %v, want %v", src, test.dst, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	MediaServerJellyfin = "jellyfin"
+	MediaServerPlex     = "plex"
+)
+
+// above this many directories, the whole library is refreshed instead
+const maxTargetedRefreshDirs = 20
+
+var mediaServerClient = &http.Client{Timeout: 30 * time.Second}
+
+// RefreshMediaServers asks every configured media server to rescan the given directories, in the background
+// each server only gets the directories of its output root
+func RefreshMediaServers(dirs []string) {
+	for _, server := range UserConfig.MediaServers {
+		root, ok := mediaServerRoot(server)
+		if !ok {
+			log.Printf("Failed to refresh %s server %s: unknown output root %q", server.Type, server.URL, server.OutputRoot)
+			continue
+		}
+
+		rootDirs := []string{}
+		for _, dir := range dirs {
+			// a nested root has its own library
+			if owner, ok := OutputRootOf(dir); !ok || owner.Name != root.Name {
+				continue
+			}
+
+			if !slices.Contains(rootDirs, dir) {
+				rootDirs = append(rootDirs, dir)
+			}
+		}
+
+		if len(rootDirs) == 0 {
+			continue
+		}
+
+		go func() {
+			if err := refreshMediaServer(server, root, rootDirs); err != nil {
+				log.Printf("Failed to refresh %s server %s: %v", server.Type, server.URL, err)
+			}
+		}()
+	}
+}
+
+func mediaServerRoot(server MediaServerConfig) (OutputRootConfig, bool) {
+	if server.OutputRoot == "" {
+		return GetOutputRoot(DefaultOutputRoot)
+	}
+	return GetOutputRoot(server.OutputRoot)
+}
+
+func refreshMediaServer(server MediaServerConfig, root OutputRootConfig, dirs []string) error {
+	serverDirs := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		serverDirs = append(serverDirs, mediaServerPath(server, root, dir))
+	}
+
+	switch server.Type {
+	case MediaServerJellyfin:
+		return refreshJellyfin(server, serverDirs)
+	case MediaServerPlex:
+		return refreshPlex(server, serverDirs)
+	default:
+		return fmt.Errorf("unknown media server type %q", server.Type)
+	}
+}
+
+// translates a directory of the output root to the path the media server knows it by
+func mediaServerPath(server MediaServerConfig, root OutputRootConfig, dir string) string {
+	if server.OutputDir == "" {
+		return dir
+	}
+
+	relativePath, err := filepath.Rel(root.Dir, dir)
+	if err != nil {
+		return dir
+	}
+
+	return path.Join(server.OutputDir, filepath.ToSlash(relativePath))
+}
+
+// https://api.jellyfin.org/#tag/Library/operation/PostUpdatedMedia
+func refreshJellyfin(server MediaServerConfig, dirs []string) error {
+	if len(dirs) > maxTargetedRefreshDirs {
+		endpoint := "/Library/Refresh"
+		if server.LibraryID != "" {
+			endpoint = "/Items/" + url.PathEscape(server.LibraryID) + "/Refresh?Recursive=true"
+		}
+
+		return sendMediaServerRequest(server, http.MethodPost, endpoint, nil)
+	}
+
+	type mediaUpdate struct {
+		Path       string `json:"Path"`
+		UpdateType string `json:"UpdateType"`
+	}
+
+	updates := []mediaUpdate{}
+	for _, dir := range dirs {
+		updates = append(updates, mediaUpdate{Path: dir, UpdateType: "Modified"})
+	}
+
+	body, err := json.Marshal(map[string][]mediaUpdate{"Updates": updates})
+	if err != nil {
+		return err
+	}
+
+	return sendMediaServerRequest(server, http.MethodPost, "/Library/Media/Updated", body)
+}
+
+// https://support.plex.tv/articles/201638786-plex-media-server-url-commands/
+func refreshPlex(server MediaServerConfig, dirs []string) error {
+	if server.LibraryID == "" {
+		return fmt.Errorf("library_id is required for plex")
+	}
+
+	endpoint := "/library/sections/" + url.PathEscape(server.LibraryID) + "/refresh"
+
+	if len(dirs) > maxTargetedRefreshDirs {
+		return sendMediaServerRequest(server, http.MethodGet, endpoint, nil)
+	}
+
+	for _, dir := range dirs {
+		err := sendMediaServerRequest(server, http.MethodGet, endpoint+"?path="+url.QueryEscape(dir), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sendMediaServerRequest(server MediaServerConfig, method string, endpoint string, body []byte) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(server.URL, "/")+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	switch server.Type {
+	case MediaServerJellyfin:
+		req.Header.Set("Authorization", fmt.Sprintf("MediaBrowser Token=%q", server.APIKey))
+	case MediaServerPlex:
+		req.Header.Set("X-Plex-Token", server.APIKey)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := mediaServerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", method, endpoint, res.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
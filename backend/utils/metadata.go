@@ -1,30 +1,51 @@
 package utils
 
 import (
-	"github.com/dhowden/tag"
-	"log"
+	"errors"
 	"os"
+
+	"github.com/dhowden/tag"
 )
 
-func GetMetadataFromFile(audioFilePath string) (tag.Metadata, error) {
-	// Check if the file exists
-	if _, err := os.Stat(audioFilePath); os.IsNotExist(err) {
-		log.Fatalf("Audio file not found: %s", audioFilePath)
-	}
+// MetadataReader reads the tags of an audio file
+type MetadataReader interface {
+	ReadMetadata(path string) (tag.Metadata, error)
+}
+
+// tried in order until one of them can read the file
+var metadataReaders = []MetadataReader{
+	NativeMetadataReader{},
+	FfprobeMetadataReader{},
+}
 
-	file, err := os.Open(audioFilePath)
+// NativeMetadataReader parses the tags in Go, it handles id3, mp4, flac and ogg files
+type NativeMetadataReader struct{}
 
+func (NativeMetadataReader) ReadMetadata(path string) (tag.Metadata, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("Error opening file: %v", err)
+		return nil, err
 	}
-
 	defer file.Close()
 
-	metadata, err := tag.ReadFrom(file)
+	return tag.ReadFrom(file)
+}
 
-	if err != nil {
+// GetMetadataFromFile reads the tags of an audio file with the first reader that supports it
+func GetMetadataFromFile(audioFilePath string) (tag.Metadata, error) {
+	if _, err := os.Stat(audioFilePath); err != nil {
 		return nil, err
 	}
 
-	return metadata, nil
+	errs := []error{}
+
+	for _, reader := range metadataReaders {
+		metadata, err := reader.ReadMetadata(audioFilePath)
+		if err == nil {
+			return metadata, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
 }
@@ -0,0 +1,317 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// identifies scyd to MusicBrainz, anonymous clients get throttled
+const musicBrainzUserAgent = "scyd/1.0.0 ( https://github.com/nicolassutter/scyd )"
+
+var musicBrainzClient = &http.Client{Timeout: 30 * time.Second}
+
+// MusicBrainz allows one request per second
+var musicBrainzRateLimit = struct {
+	sync.Mutex
+	lastRequest time.Time
+}{}
+
+// a recording of MusicBrainz on one of its releases
+type MusicBrainzMatch struct {
+	RecordingID string
+	Title       string
+	ArtistID    string
+	Artist      string
+	ReleaseID   string
+	Album       string
+	AlbumArtist string
+	TrackNumber int
+	DiscNumber  int
+	Year        int
+	// 0 to 100, how well the recording matches the query
+	Score int
+}
+
+type musicBrainzArtistCredit struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+	Artist     struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"artist"`
+}
+
+type musicBrainzTrack struct {
+	Number string `json:"number"`
+}
+
+type musicBrainzMedia struct {
+	Position int `json:"position"`
+	// search results name the tracks "track", lookups "tracks"
+	Track  []musicBrainzTrack `json:"track"`
+	Tracks []musicBrainzTrack `json:"tracks"`
+}
+
+type musicBrainzRelease struct {
+	ID           string                    `json:"id"`
+	Title        string                    `json:"title"`
+	Status       string                    `json:"status"`
+	Date         string                    `json:"date"`
+	ArtistCredit []musicBrainzArtistCredit `json:"artist-credit"`
+	ReleaseGroup struct {
+		PrimaryType    string   `json:"primary-type"`
+		SecondaryTypes []string `json:"secondary-types"`
+	} `json:"release-group"`
+	Media []musicBrainzMedia `json:"media"`
+}
+
+type musicBrainzRecording struct {
+	ID           string                    `json:"id"`
+	Title        string                    `json:"title"`
+	Score        int                       `json:"score"`
+	ArtistCredit []musicBrainzArtistCredit `json:"artist-credit"`
+	Releases     []musicBrainzRelease      `json:"releases"`
+}
+
+func musicBrainzCreditName(credits []musicBrainzArtistCredit) string {
+	var name strings.Builder
+	for _, credit := range credits {
+		name.WriteString(credit.Name + credit.JoinPhrase)
+	}
+	return name.String()
+}
+
+// prefers official studio albums, then the earliest release
+func preferredRelease(releases []musicBrainzRelease) *musicBrainzRelease {
+	if len(releases) == 0 {
+		return nil
+	}
+
+	rank := func(release musicBrainzRelease) int {
+		rank := 0
+		if release.Status == "Official" {
+			rank += 4
+		}
+		if release.ReleaseGroup.PrimaryType == "Album" {
+			rank += 2
+		}
+		if len(release.ReleaseGroup.SecondaryTypes) == 0 {
+			rank++
+		}
+		return rank
+	}
+
+	sorted := append([]musicBrainzRelease{}, releases...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if rank(sorted[i]) != rank(sorted[j]) {
+			return rank(sorted[i]) > rank(sorted[j])
+		}
+		// undated releases last
+		if (sorted[i].Date == "") != (sorted[j].Date == "") {
+			return sorted[j].Date == ""
+		}
+		return sorted[i].Date < sorted[j].Date
+	})
+
+	return &sorted[0]
+}
+
+func (recording musicBrainzRecording) toMatch() MusicBrainzMatch {
+	match := MusicBrainzMatch{
+		RecordingID: recording.ID,
+		Title:       recording.Title,
+		Artist:      musicBrainzCreditName(recording.ArtistCredit),
+		Score:       recording.Score,
+	}
+
+	if len(recording.ArtistCredit) > 0 {
+		match.ArtistID = recording.ArtistCredit[0].Artist.ID
+	}
+
+	release := preferredRelease(recording.Releases)
+	if release == nil {
+		return match
+	}
+
+	match.ReleaseID = release.ID
+	match.Album = release.Title
+	match.AlbumArtist = musicBrainzCreditName(release.ArtistCredit)
+	if match.AlbumArtist == "" {
+		match.AlbumArtist = match.Artist
+	}
+
+	if len(release.Date) >= 4 {
+		match.Year, _ = strconv.Atoi(release.Date[:4])
+	}
+
+	// the media of a recording's release only list the track of that recording
+	for _, media := range release.Media {
+		tracks := append(media.Track, media.Tracks...)
+		if len(tracks) == 0 {
+			continue
+		}
+
+		match.DiscNumber = media.Position
+		match.TrackNumber, _ = strconv.Atoi(tracks[0].Number)
+		break
+	}
+
+	return match
+}
+
+func musicBrainzGet(endpoint string, query url.Values, result any) error {
+	musicBrainzRateLimit.Lock()
+	if wait := time.Second - time.Since(musicBrainzRateLimit.lastRequest); wait > 0 {
+		time.Sleep(wait)
+	}
+	musicBrainzRateLimit.lastRequest = time.Now()
+	musicBrainzRateLimit.Unlock()
+
+	query.Set("fmt", "json")
+	requestURL := strings.TrimSuffix(UserConfig.Enrichment.MusicBrainzURL, "/") + endpoint + "?" + query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := musicBrainzClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("GET %s: %s %s", endpoint, res.Status, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// quotes a value for the lucene query syntax of the search API
+func musicBrainzQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// SearchMusicBrainzRecordings returns the recordings matching a title and an optional artist, best first
+func SearchMusicBrainzRecordings(artist string, title string) ([]MusicBrainzMatch, error) {
+	query := "recording:" + musicBrainzQuote(title)
+	if artist != "" {
+		query += " AND artist:" + musicBrainzQuote(artist)
+	}
+
+	var result struct {
+		Recordings []musicBrainzRecording `json:"recordings"`
+	}
+
+	err := musicBrainzGet("/recording", url.Values{"query": {query}, "limit": {"5"}}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]MusicBrainzMatch, 0, len(result.Recordings))
+	for _, recording := range result.Recordings {
+		matches = append(matches, recording.toMatch())
+	}
+
+	return matches, nil
+}
+
+// GetMusicBrainzRecording looks up a recording by its MBID
+func GetMusicBrainzRecording(recordingID string) (*MusicBrainzMatch, error) {
+	var recording musicBrainzRecording
+
+	err := musicBrainzGet("/recording/"+url.PathEscape(recordingID), url.Values{"inc": {"artists releases media release-groups"}}, &recording)
+	if err != nil {
+		return nil, err
+	}
+
+	match := recording.toMatch()
+	return &match, nil
+}
+
+var ErrFpcalcNotFound = errors.New("fpcalc is not installed")
+
+// LookupAcoustID fingerprints the audio file with fpcalc and returns the best matching recording MBID
+// with the AcoustID score (0 to 100), an empty ID when nothing matched
+func LookupAcoustID(path string) (string, int, error) {
+	if _, err := exec.LookPath("fpcalc"); err != nil {
+		return "", 0, ErrFpcalcNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "fpcalc", "-json", path).Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("fpcalc failed: %w", err)
+	}
+
+	var fingerprint struct {
+		Duration    float64 `json:"duration"`
+		Fingerprint string  `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(output, &fingerprint); err != nil {
+		return "", 0, err
+	}
+
+	form := url.Values{
+		"client":      {UserConfig.Enrichment.AcoustIDKey},
+		"meta":        {"recordingids"},
+		"duration":    {strconv.Itoa(int(math.Round(fingerprint.Duration)))},
+		"fingerprint": {fingerprint.Fingerprint},
+	}
+
+	// fingerprints are too long for a query string
+	res, err := musicBrainzClient.PostForm(strings.TrimSuffix(UserConfig.Enrichment.AcoustIDURL, "/")+"/lookup", form)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Error  struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Results []struct {
+			Score      float64 `json:"score"`
+			Recordings []struct {
+				ID string `json:"id"`
+			} `json:"recordings"`
+		} `json:"results"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", 0, fmt.Errorf("acoustid lookup: %s", res.Status)
+	}
+
+	if result.Status != "ok" {
+		return "", 0, fmt.Errorf("acoustid lookup: %s %s", res.Status, result.Error.Message)
+	}
+
+	// results are sorted by score
+	for _, candidate := range result.Results {
+		if len(candidate.Recordings) > 0 {
+			return candidate.Recordings[0].ID, int(math.Round(candidate.Score * 100)), nil
+		}
+	}
+
+	return "", 0, nil
+}
@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serves the given body for every request and records the last one
+func newStandIn(t *testing.T, status int, body string) (*httptest.Server, **http.Request) {
+	var lastRequest *http.Request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		lastRequest = r
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	// the stand-in doesn't need the one request per second of MusicBrainz
+	musicBrainzRateLimit.lastRequest = time.Time{}
+
+	return server, &lastRequest
+}
+
+func useEnrichmentURLs(t *testing.T, musicBrainzURL string, acoustIDURL string) {
+	previous := UserConfig.Enrichment
+	t.Cleanup(func() { UserConfig.Enrichment = previous })
+
+	UserConfig.Enrichment.MusicBrainzURL = musicBrainzURL
+	UserConfig.Enrichment.AcoustIDURL = acoustIDURL
+}
+
+const musicBrainzSearchBody = `{
+	"recordings": [{
+		"id": "rec-1",
+		"title": "Song",
+		"score": 97,
+		"artist-credit": [
+			{"name": "Artist", "joinphrase": " feat. ", "artist": {"id": "artist-1", "name": "Artist"}},
+			{"name": "Guest", "joinphrase": "", "artist": {"id": "artist-2", "name": "Guest"}}
+		],
+		"releases": [
+			{
+				"id": "bootleg", "title": "Live Bootleg", "status": "Bootleg", "date": "1999",
+				"release-group": {"primary-type": "Album"},
+				"media": [{"position": 1, "track": [{"number": "7"}]}]
+			},
+			{
+				"id": "compilation", "title": "Best Of", "status": "Official", "date": "2001-05-01",
+				"release-group": {"primary-type": "Album", "secondary-types": ["Compilation"]},
+				"media": [{"position": 1, "track": [{"number": "3"}]}]
+			},
+			{
+				"id": "undated", "title": "Reissue", "status": "Official",
+				"release-group": {"primary-type": "Album"},
+				"media": [{"position": 1, "track": [{"number": "1"}]}]
+			},
+			{
+				"id": "album", "title": "Album", "status": "Official", "date": "2003-02-01",
+				"artist-credit": [{"name": "Artist", "joinphrase": "", "artist": {"id": "artist-1", "name": "Artist"}}],
+				"release-group": {"primary-type": "Album"},
+				"media": [{"position": 2, "track": [{"number": "5"}]}]
+			}
+		]
+	}, {
+		"id": "rec-2",
+		"title": "Song (Remix)",
+		"score": 60,
+		"artist-credit": [{"name": "Other", "joinphrase": "", "artist": {"id": "artist-3", "name": "Other"}}]
+	}]
+}`
+
+func TestSearchMusicBrainzRecordings(t *testing.T) {
+	server, lastRequest := newStandIn(t, http.StatusOK, musicBrainzSearchBody)
+	useEnrichmentURLs(t, server.URL+"/ws/2/", "")
+
+	matches, err := SearchMusicBrainzRecordings("Artist", `Song "Live"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := *lastRequest
+	if request.URL.Path != "/ws/2/recording" {
+		t.Errorf("path = %q, want /ws/2/recording", request.URL.Path)
+	}
+	if query := request.Form.Get("query"); query != `recording:"Song \"Live\"" AND artist:"Artist"` {
+		t.Errorf("query = %q", query)
+	}
+	if request.Form.Get("fmt") != "json" || request.UserAgent() != musicBrainzUserAgent {
+		t.Errorf("fmt = %q, user agent = %q", request.Form.Get("fmt"), request.UserAgent())
+	}
+
+	if len(matches) != 2 {
+		t.Fatalf("got %d matches, want 2", len(matches))
+	}
+
+	want := MusicBrainzMatch{
+		RecordingID: "rec-1",
+		Title:       "Song",
+		ArtistID:    "artist-1",
+		Artist:      "Artist feat. Guest",
+		ReleaseID:   "album",
+		Album:       "Album",
+		AlbumArtist: "Artist",
+		TrackNumber: 5,
+		DiscNumber:  2,
+		Year:        2003,
+		Score:       97,
+	}
+	if matches[0] != want {
+		t.Errorf("first match = %+v, want %+v", matches[0], want)
+	}
+
+	// without releases only the recording is known
+	want = MusicBrainzMatch{RecordingID: "rec-2", Title: "Song (Remix)", ArtistID: "artist-3", Artist: "Other", Score: 60}
+	if matches[1] != want {
+		t.Errorf("second match = %+v, want %+v", matches[1], want)
+	}
+}
+
+func TestGetMusicBrainzRecording(t *testing.T) {
+	// lookups name the tracks of a medium "tracks", the release without artist credit falls back to the recording artist
+	server, lastRequest := newStandIn(t, http.StatusOK, `{
+		"id": "rec-1", "title": "Song",
+		"artist-credit": [{"name": "Artist", "joinphrase": "", "artist": {"id": "artist-1", "name": "Artist"}}],
+		"releases": [{
+			"id": "single", "title": "Song", "status": "Official", "date": "2010",
+			"release-group": {"primary-type": "Single"},
+			"media": [{"position": 1, "tracks": [{"number": "A1"}]}, {"position": 2, "tracks": [{"number": "2"}]}]
+		}]
+	}`)
+	useEnrichmentURLs(t, server.URL, "")
+
+	match, err := GetMusicBrainzRecording("rec-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (*lastRequest).URL.Path != "/recording/rec-1" {
+		t.Errorf("path = %q, want /recording/rec-1", (*lastRequest).URL.Path)
+	}
+
+	// vinyl track numbers like "A1" are not numbers
+	want := MusicBrainzMatch{RecordingID: "rec-1", Title: "Song", ArtistID: "artist-1", Artist: "Artist", ReleaseID: "single", Album: "Song", AlbumArtist: "Artist", DiscNumber: 1, Year: 2010}
+	if *match != want {
+		t.Errorf("match = %+v, want %+v", *match, want)
+	}
+}
+
+func TestSearchMusicBrainzRecordingsError(t *testing.T) {
+	server, _ := newStandIn(t, http.StatusServiceUnavailable, `{"error": "rate limited"}`)
+	useEnrichmentURLs(t, server.URL, "")
+
+	_, err := SearchMusicBrainzRecordings("", "Song")
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "rate limited") {
+		t.Errorf("err = %v, want the status and the message", err)
+	}
+}
+
+// puts a fake fpcalc first in PATH
+func useFakeFpcalc(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho '{\"duration\": 181.6, \"fingerprint\": \"AQAAfake\"}'\n"
+
+	if err := os.WriteFile(filepath.Join(dir, "fpcalc"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestLookupAcoustID(t *testing.T) {
+	useFakeFpcalc(t)
+
+	server, lastRequest := newStandIn(t, http.StatusOK, `{
+		"status": "ok",
+		"results": [
+			{"score": 0.95, "recordings": []},
+			{"score": 0.874, "recordings": [{"id": "rec-1"}, {"id": "rec-2"}]}
+		]
+	}`)
+	useEnrichmentURLs(t, "", server.URL+"/v2")
+	UserConfig.Enrichment.AcoustIDKey = "key"
+
+	recordingID, score, err := LookupAcoustID("song.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recordingID != "rec-1" || score != 87 {
+		t.Errorf("got %q with score %d, want rec-1 with score 87", recordingID, score)
+	}
+
+	request := *lastRequest
+	if request.Method != http.MethodPost || request.URL.Path != "/v2/lookup" {
+		t.Errorf("request = %s %s, want POST /v2/lookup", request.Method, request.URL.Path)
+	}
+	if request.PostForm.Get("client") != "key" || request.PostForm.Get("duration") != "182" || request.PostForm.Get("fingerprint") != "AQAAfake" {
+		t.Errorf("form = %v", request.PostForm)
+	}
+}
+
+func TestLookupAcoustIDErrors(t *testing.T) {
+	useFakeFpcalc(t)
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   []string
+	}{
+		{"error status", http.StatusBadRequest, `{"status": "error", "error": {"code": 4, "message": "invalid API key"}}`, []string{"400", "invalid API key"}},
+		{"not ok with 200", http.StatusOK, `{"status": "error", "error": {"message": "too many requests"}}`, []string{"200", "too many requests"}},
+		{"not json", http.StatusBadGateway, `<html>bad gateway</html>`, []string{"502"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newStandIn(t, test.status, test.body)
+			useEnrichmentURLs(t, "", server.URL)
+
+			_, _, err := LookupAcoustID("song.mp3")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const DefaultOutputRoot = "default"
+
+const DefaultPathTemplate = "{artist}/{album}/{file_name}"
+
+// IsWithinDir reports whether path is dir or one of its descendants
+func IsWithinDir(path string, dir string) bool {
+	relativePath, err := filepath.Rel(dir, path)
+	return err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+// OutputRoots returns the output dir as the "default" root, followed by the configured roots
+func OutputRoots() []OutputRootConfig {
+	roots := []OutputRootConfig{{Name: DefaultOutputRoot, Dir: UserConfig.OutputDir, PathTemplate: DefaultPathTemplate}}
+
+	for _, root := range UserConfig.OutputRoots {
+		if root.PathTemplate == "" {
+			root.PathTemplate = DefaultPathTemplate
+		}
+		roots = append(roots, root)
+	}
+
+	return roots
+}
+
+// OutputRootDirs returns the directory of every output root
+func OutputRootDirs() []string {
+	dirs := []string{}
+	for _, root := range OutputRoots() {
+		dirs = append(dirs, root.Dir)
+	}
+	return dirs
+}
+
+// GetOutputRoot returns the output root with the given name
+func GetOutputRoot(name string) (OutputRootConfig, bool) {
+	for _, root := range OutputRoots() {
+		if root.Name == name {
+			return root, true
+		}
+	}
+	return OutputRootConfig{}, false
+}
+
+// OutputRootOf returns the output root holding path, the most specific one when roots are nested
+func OutputRootOf(path string) (OutputRootConfig, bool) {
+	var found *OutputRootConfig
+
+	for _, root := range OutputRoots() {
+		if IsWithinDir(path, root.Dir) && (found == nil || len(root.Dir) > len(found.Dir)) {
+			found = &root
+		}
+	}
+
+	if found == nil {
+		return OutputRootConfig{}, false
+	}
+	return *found, true
+}
+
+// TitleMatches reports whether the title matches the title regex of the rule, always true without one
+func (rule RoutingRuleConfig) TitleMatches(title string) bool {
+	return rule.titleRegex == nil || rule.titleRegex.MatchString(title)
+}
+
+// checks the names and directories of the output roots and compiles the title regexes of the routing rules
+func validateOutputRoots() error {
+	names := map[string]bool{DefaultOutputRoot: true}
+
+	for i, root := range UserConfig.OutputRoots {
+		if root.Name == "" || root.Dir == "" {
+			return fmt.Errorf("output root %d needs a name and a dir", i+1)
+		}
+		if names[root.Name] {
+			return fmt.Errorf("output root %q is defined twice", root.Name)
+		}
+		names[root.Name] = true
+
+		UserConfig.OutputRoots[i].Dir = filepath.Clean(root.Dir)
+	}
+
+	for i, rule := range UserConfig.Routing {
+		if rule.Output == "" {
+			UserConfig.Routing[i].Output = DefaultOutputRoot
+		} else if !names[rule.Output] {
+			return fmt.Errorf("routing rule %q sends files to unknown output root %q", rule.Name, rule.Output)
+		}
+
+		if rule.TitleRegex != "" {
+			titleRegex, err := regexp.Compile(rule.TitleRegex)
+			if err != nil {
+				return fmt.Errorf("routing rule %q: %w", rule.Name, err)
+			}
+			UserConfig.Routing[i].titleRegex = titleRegex
+		}
+	}
+
+	for _, server := range UserConfig.MediaServers {
+		if server.OutputRoot != "" && !names[server.OutputRoot] {
+			return fmt.Errorf("%s server %s uses unknown output root %q", server.Type, server.URL, server.OutputRoot)
+		}
+	}
+
+	return nil
+}
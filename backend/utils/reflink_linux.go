@@ -0,0 +1,52 @@
+//go:build linux
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// clones src into dst with the FICLONE ioctl (btrfs, xfs, ...)
+// the clone is created under a temporary name and renamed into place once complete
+func reflinkFile(src, dst string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	sourceInfo, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	err = unix.IoctlFileClone(int(tmpFile.Fd()), int(sourceFile.Fd()))
+	if err == nil {
+		err = tmpFile.Chmod(sourceInfo.Mode().Perm())
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmpPath, sourceInfo.ModTime(), sourceInfo.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dst)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package utils
+
+import "fmt"
+
+type Role string
+
+const (
+	// can do everything, including sorting, maintenance and passing raw yt-dlp args
+	RoleAdmin Role = "admin"
+	// downloads and manages the library
+	RoleUser Role = "user"
+	// read only access to the downloads and the library
+	RoleViewer Role = "viewer"
+)
+
+// GetUserRole returns the role of a configured user, false when the user doesn't exist
+func GetUserRole(username string) (Role, bool) {
+	user, exists := UserConfig.Users[username]
+	if !exists {
+		return "", false
+	}
+	return user.Role, true
+}
+
+// checks the roles of the users, users without one are admins like before roles existed
+func validateUsers() error {
+	for username, user := range UserConfig.Users {
+		switch user.Role {
+		case "":
+			user.Role = RoleAdmin
+			UserConfig.Users[username] = user
+		case RoleAdmin, RoleUser, RoleViewer:
+		default:
+			return fmt.Errorf("user %q has unknown role %q, expected admin, user or viewer", username, user.Role)
+		}
+	}
+
+	return nil
+}
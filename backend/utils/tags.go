@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+var ErrUnsupportedTagFormat = errors.New("writing tags is not supported for this format")
+var ErrInvalidCover = errors.New("cover must be a JPEG or PNG image")
+
+// formats whose tags can be written, value: whether cover art can be embedded
+var tagWritableFormats = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".m4a":  true,
+	".opus": false,
+	".ogg":  false,
+}
+
+// a partial update of the tags of an audio file, nil fields are left untouched
+// an empty string or 0 removes the tag
+type TagUpdate struct {
+	Title       *string
+	Artist      *string
+	AlbumArtist *string
+	Album       *string
+	TrackNumber *int
+	DiscNumber  *int
+	Year        *int
+	Genre       *string
+	// JPEG or PNG image replacing the embedded cover
+	Cover []byte
+	// MusicBrainz identifiers (MBIDs), not written to m4a files
+	MusicBrainzRecordingID *string
+	MusicBrainzReleaseID   *string
+	MusicBrainzArtistID    *string
+}
+
+func (u TagUpdate) ffmpegMetadata(ext string) []string {
+	metadata := []string{}
+
+	addString := func(key string, value *string) {
+		if value != nil {
+			metadata = append(metadata, key+"="+*value)
+		}
+	}
+
+	addInt := func(key string, value *int) {
+		if value == nil {
+			return
+		}
+
+		if *value == 0 {
+			metadata = append(metadata, key+"=")
+		} else {
+			metadata = append(metadata, key+"="+strconv.Itoa(*value))
+		}
+	}
+
+	addString("title", u.Title)
+	addString("artist", u.Artist)
+	addString("album_artist", u.AlbumArtist)
+	addString("album", u.Album)
+	addInt("track", u.TrackNumber)
+	addInt("disc", u.DiscNumber)
+	addInt("date", u.Year)
+	addString("genre", u.Genre)
+
+	// same names as MusicBrainz Picard, ffmpeg writes unknown keys as TXXX frames in mp3 files
+	// the mp4 muxer drops unknown keys
+	switch ext {
+	case ".flac", ".ogg", ".opus":
+		addString("MUSICBRAINZ_TRACKID", u.MusicBrainzRecordingID)
+		addString("MUSICBRAINZ_ALBUMID", u.MusicBrainzReleaseID)
+		addString("MUSICBRAINZ_ARTISTID", u.MusicBrainzArtistID)
+	case ".mp3":
+		addString("MusicBrainz Track Id", u.MusicBrainzRecordingID)
+		addString("MusicBrainz Album Id", u.MusicBrainzReleaseID)
+		addString("MusicBrainz Artist Id", u.MusicBrainzArtistID)
+	}
+
+	return metadata
+}
+
+// IsTagWritable reports whether WriteTags supports the file format
+func IsTagWritable(path string) bool {
+	_, ok := tagWritableFormats[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// WriteTags rewrites the tags of an audio file with ffmpeg, the audio stream is copied untouched
+// The file is written next to the original and renamed over it once complete
+func WriteTags(path string, update TagUpdate) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	supportsCover, ok := tagWritableFormats[ext]
+
+	if !ok {
+		return ErrUnsupportedTagFormat
+	}
+
+	if update.Cover != nil && !supportsCover {
+		return fmt.Errorf("%w: cover art can't be embedded in %s files", ErrUnsupportedTagFormat, ext)
+	}
+
+	// keep the extension last so ffmpeg picks the right muxer
+	tmpPath := filepath.Join(filepath.Dir(path), "."+strings.TrimSuffix(filepath.Base(path), ext)+".tagging"+ext)
+	defer os.Remove(tmpPath)
+
+	args := []string{"-i", path}
+
+	if update.Cover != nil {
+		coverFile, err := writeCoverTempFile(update.Cover)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(coverFile)
+
+		// replaces any embedded picture with the new one
+		args = append(args,
+			"-i", coverFile,
+			"-map", "0:a",
+			"-map", "1:0",
+			"-disposition:v:0", "attached_pic",
+			"-metadata:s:v:0", "title=Album cover",
+			"-metadata:s:v:0", "comment=Cover (front)",
+		)
+	} else {
+		args = append(args, "-map", "0")
+	}
+
+	args = append(args, "-c", "copy")
+
+	// ogg based formats keep their tags on the audio stream rather than globally
+	metadataFlag := "-metadata"
+	if ext == ".opus" || ext == ".ogg" {
+		metadataFlag = "-metadata:s:a:0"
+	}
+
+	for _, entry := range update.ffmpegMetadata(ext) {
+		args = append(args, metadataFlag, entry)
+	}
+
+	if ext == ".mp3" {
+		// widest player support
+		args = append(args, "-id3v2_version", "3")
+	}
+
+	args = append(args, tmpPath)
+
+	if err := RunFfmpeg(context.Background(), args...); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// writes the image to a temporary file with the extension matching its content
+func writeCoverTempFile(data []byte) (string, error) {
+	ext := ""
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/png":
+		ext = ".png"
+	default:
+		return "", ErrInvalidCover
+	}
+
+	file, err := os.CreateTemp("", "scyd-cover-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// MusicBrainzRecordingID returns the recording MBID stored in the tags, or an empty string
+func MusicBrainzRecordingID(metadata tag.Metadata) string {
+	for key, value := range metadata.Raw() {
+		switch value := value.(type) {
+		case *tag.Comm:
+			// id3 TXXX frames
+			if strings.EqualFold(value.Description, "MusicBrainz Track Id") {
+				return value.Text
+			}
+		case *tag.UFID:
+			if value.Provider == "http://musicbrainz.org" {
+				return string(value.Identifier)
+			}
+		case string:
+			// vorbis comments, or any format read by ffprobe
+			if strings.EqualFold(key, "musicbrainz_trackid") || strings.EqualFold(key, "MusicBrainz Track Id") {
+				return value
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchEvents = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO
+
+// WatchDirectory calls onChange with the path of every file created, written or moved into root
+// or one of its subdirectories, until ctx is done
+// onChange is called from a single goroutine
+func WatchDirectory(ctx context.Context, root string, onChange func(path string)) error {
+	if _, err := os.Stat(root); err != nil {
+		return err
+	}
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+
+	// a non blocking fd goes through the runtime poller, closing the file unblocks Read
+	inotifyFile := os.NewFile(uintptr(fd), "inotify")
+
+	// key: watch descriptor
+	watchedDirs := map[int]string{}
+
+	// watches dir and its subdirectories, reporting the files already in them
+	addWatches := func(dir string, report bool) {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			if !d.IsDir() {
+				if report {
+					onChange(path)
+				}
+				return nil
+			}
+
+			wd, err := unix.InotifyAddWatch(fd, path, watchEvents)
+			if err != nil {
+				log.Printf("Failed to watch %s: %v", path, err)
+				return nil
+			}
+
+			watchedDirs[wd] = path
+			return nil
+		})
+	}
+
+	addWatches(root, false)
+
+	go func() {
+		<-ctx.Done()
+		inotifyFile.Close()
+	}()
+
+	go func() {
+		buffer := make([]byte, 64*1024)
+
+		for {
+			n, err := inotifyFile.Read(buffer)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Stopped watching %s: %v", root, err)
+				}
+				return
+			}
+
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				event := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				nameBytes := buffer[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+				name := string(bytes.TrimRight(nameBytes, "\x00"))
+				offset += unix.SizeofInotifyEvent + int(event.Len)
+
+				if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+					// events were lost, whatever is in the directory has to be looked at
+					onChange(root)
+					continue
+				}
+
+				if event.Mask&unix.IN_IGNORED != 0 {
+					delete(watchedDirs, int(event.Wd))
+					continue
+				}
+
+				dir, ok := watchedDirs[int(event.Wd)]
+				if !ok || name == "" {
+					continue
+				}
+
+				path := filepath.Join(dir, name)
+
+				// directories copied or moved in already contain files that won't send events
+				if event.Mask&unix.IN_ISDIR != 0 {
+					if event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+						addWatches(path, true)
+					}
+					continue
+				}
+
+				onChange(path)
+			}
+		}
+	}()
+
+	return nil
+}
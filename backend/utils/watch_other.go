@@ -0,0 +1,12 @@
+//go:build !linux
+
+package utils
+
+import (
+	"context"
+	"errors"
+)
+
+func WatchDirectory(ctx context.Context, root string, onChange func(path string)) error {
+	return errors.ErrUnsupported
+}
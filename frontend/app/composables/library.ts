@@ -0,0 +1,66 @@
+import { keepPreviousData, useQuery } from "@tanstack/vue-query";
+import { createGlobalState } from "@vueuse/core";
+import { client } from "~/utils/client/client.gen";
+
+export type LibraryTrack = {
+  id: number;
+  path: string;
+  title: string;
+  artist?: { id: number; name: string };
+  album?: { id: number; title: string };
+  track_number: number;
+  year: number;
+  genre: string;
+  format: string;
+};
+
+type LibraryTracksPage = {
+  total: number;
+  page: number;
+  page_size: number;
+  tracks: LibraryTrack[];
+};
+
+export const useLibrary = createGlobalState(() => {
+  const apiBaseUrl = client.getConfig().baseUrl ?? "";
+
+  const search = ref("");
+  const page = ref(1);
+  const pageSize = 20;
+
+  // go back to the first page when the search changes
+  watch(search, () => {
+    page.value = 1;
+  });
+
+  const tracksQuery = useQuery({
+    queryKey: ["library-tracks", search, page],
+    queryFn: async () => {
+      const response = await client.get<{ 200: LibraryTracksPage }>({
+        url: "/api/v1/library/tracks",
+        query: {
+          q: search.value || undefined,
+          page: page.value,
+          page_size: pageSize,
+        },
+      });
+      return response.data;
+    },
+    placeholderData: keepPreviousData,
+  });
+
+  /**
+   * URL of the audio stream of a track, usable as an <audio> source
+   */
+  function trackStreamUrl(id: number) {
+    return `${apiBaseUrl}/api/v1/library/tracks/${id}/stream`;
+  }
+
+  return {
+    search,
+    page,
+    pageSize,
+    tracksQuery,
+    trackStreamUrl,
+  };
+});